@@ -0,0 +1,17 @@
+// Сервер отдаёт адреса загруженных картинок относительными (/api/image/get/...),
+// а внешние ссылки на картинки оставляем как есть
+const SERVER_URL = 'http://localhost:8080'
+
+// Возвращает полный адрес картинки. В params можно передать width, height,
+// fit и format — тогда сервер отдаст уменьшенную копию
+export const imageUrl = (path, params = {}) => {
+  if (!path || !path.startsWith('/api/')) {
+    return path
+  }
+
+  const url = new URL(path, SERVER_URL)
+  Object.entries(params).forEach(([key, value]) => {
+    url.searchParams.set(key, value)
+  })
+  return url.toString()
+}
@@ -1,13 +1,31 @@
 import axios from 'axios'
 // Базовый URL для mock API (json-server)
 const API_URL = 'http://localhost:8080/api/products/get_all'
-// Функция для загрузки товаров
-export const fetchProducts = async () => {
+// Сколько товаров загружать за раз
+const PAGE_SIZE = 20
+// Функция для загрузки одной страницы товаров. Для следующей страницы
+// передаётся cursor из предыдущего ответа и те же фильтры
+export const fetchProducts = async (params = {}) => {
   try {
-    const response = await axios.get(API_URL)
-    return response.data // Возвращаем данные товаров
+    const response = await axios.get(API_URL, {
+      params: { limit: PAGE_SIZE, ...params },
+    })
+    return {
+      items: response.data.items,
+      nextCursor: response.data.next_cursor, // Пустой, если страниц больше нет
+    }
   } catch (error) {
     console.error('Ошибка при загрузке товаров:', error)
     throw error // Пробрасываем ошибку, чтобы обработать её в компоненте
   }
 }
+// Функция для загрузки одного товара по id
+export const fetchProductById = async (id) => {
+  try {
+    const response = await axios.get(`http://localhost:8080/api/products/${id}`)
+    return response.data
+  } catch (error) {
+    console.error('Ошибка при загрузке товара:', error)
+    throw error
+  }
+}
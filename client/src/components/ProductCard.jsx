@@ -12,6 +12,7 @@ import { motion } from 'framer-motion'
 import { useDispatch } from 'react-redux'
 import { addCartItem, fetchCart } from '../features/cartSlice'
 import { Link } from 'react-router-dom'
+import { imageUrl } from '../api/images'
 
 const ProductCard = ({ product }) => {
   const dispatch = useDispatch()
@@ -42,7 +43,7 @@ const ProductCard = ({ product }) => {
         <CardMedia
           component='img'
           height='170'
-          image={product.image}
+          image={imageUrl(product.image, { height: 340 })}
           alt={product.title}
           sx={{
             objectPosition: 'top',
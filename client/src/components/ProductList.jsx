@@ -2,6 +2,7 @@ import React, { useEffect } from 'react'
 import { useDispatch, useSelector } from 'react-redux'
 import {
   loadProducts,
+  loadMoreProducts,
   setCategory,
   setSortBy,
   setSearchQuery,
@@ -14,27 +15,12 @@ import { Button } from '@mui/material'
 
 const ProductList = () => {
   const dispatch = useDispatch()
-  const { items, status, category, sortBy, searchQuery } = useSelector(
-    (state) => state.products
-  )
+  const { items, status, category, sortBy, searchQuery, nextCursor, loadingMore } =
+    useSelector((state) => state.products)
+  // При смене фильтров список загружается заново с первой страницы
   useEffect(() => {
     dispatch(loadProducts())
-  }, [dispatch])
-  const filteredProducts =
-    category === 'Все'
-      ? items
-      : items.filter((product) => product.category === category)
-  const searchedProducts = filteredProducts.filter((product) =>
-    product.title.toLowerCase().includes(searchQuery.toLowerCase())
-  )
-  const sortedProducts = [...searchedProducts].sort((a, b) => {
-    if (sortBy === 'priceAsc') return a.price - b.price
-    if (sortBy === 'priceDesc') return b.price - a.price
-    if (sortBy === 'rating') return b.rating - a.rating
-    return 0
-  })
-  if (status === 'loading') return <div>Loading...</div>
-  if (status === 'failed') return <div>Error loading products.</div>
+  }, [dispatch, category, sortBy, searchQuery])
   return (
     <div>
       <FilterPanel
@@ -42,13 +28,27 @@ const ProductList = () => {
       />
       <SortPanel onSortChange={(sortBy) => dispatch(setSortBy(sortBy))} />
       <SearchBar onSearchChange={(query) => dispatch(setSearchQuery(query))} />
+      {status === 'loading' && items.length === 0 && <div>Loading...</div>}
+      {status === 'failed' && <div>Error loading products.</div>}
       <div
         style={{ display: 'flex', flexWrap: 'wrap', justifyContent: 'center' }}
       >
-        {sortedProducts.map((product) => (
+        {items.map((product) => (
           <ProductCard key={product.id} product={product} />
         ))}
       </div>
+      {nextCursor && (
+        <div style={{ display: 'flex', justifyContent: 'center' }}>
+          <Button
+            variant='outlined'
+            sx={{ my: 2 }}
+            disabled={loadingMore}
+            onClick={() => dispatch(loadMoreProducts())}
+          >
+            {loadingMore ? 'Загрузка...' : 'Загрузить ещё'}
+          </Button>
+        </div>
+      )}
     </div>
   )
 }
@@ -12,9 +12,12 @@ export const registerUser = createAsyncThunk(
         {
           email,
           password,
-        }
+        },
+        // cookie гостевой корзины нужна, чтобы перенести её в аккаунт
+        { withCredentials: true }
       )
       localStorage.setItem('token', response.data.token)
+      localStorage.setItem('refreshToken', response.data.refresh_token)
       return response.data
     } catch (error) {
       return rejectWithValue(
@@ -33,9 +36,12 @@ export const loginUser = createAsyncThunk(
         {
           email,
           password,
-        }
+        },
+        // cookie гостевой корзины нужна, чтобы перенести её в аккаунт
+        { withCredentials: true }
       )
       localStorage.setItem('token', response.data.token)
+      localStorage.setItem('refreshToken', response.data.refresh_token)
       return response.data
     } catch (error) {
       return rejectWithValue(
@@ -77,6 +83,22 @@ export const getProfile = createAsyncThunk(
   }
 )
 
+// Выход: сначала отзываем сессию на сервере, иначе refresh-токен
+// остался бы действительным до конца срока. Локально выходим в любом случае.
+export const logoutUser = createAsyncThunk(
+  'auth/logoutUser',
+  async (_, { dispatch, extra }) => {
+    try {
+      const { axiosInstance } = extra
+      await axiosInstance.post('/auth/logout')
+    } catch (error) {
+      console.error('Ошибка при завершении сессии:', error)
+    } finally {
+      dispatch(logout())
+    }
+  }
+)
+
 const authSlice = createSlice({
   name: 'auth',
   initialState: {
@@ -95,6 +117,7 @@ const authSlice = createSlice({
       state.user = null
       state.token = null
       localStorage.removeItem('token')
+      localStorage.removeItem('refreshToken')
     },
     setCredentials: (state, action) => {
       state.token = action.payload
@@ -1,12 +1,42 @@
 import { createAsyncThunk, createSlice } from '@reduxjs/toolkit'
 
+export const fetchAddresses = createAsyncThunk(
+  'order/fetchAddresses',
+  async (_, { rejectWithValue, extra }) => {
+    try {
+      const { axiosInstance } = extra
+      const { data } = await axiosInstance.get('/addresses')
+      return data.addresses
+    } catch (error) {
+      return rejectWithValue(error.response?.data || error.message)
+    }
+  }
+)
+
+// Сохраняет новый адрес в адресную книгу и возвращает его вместе с id
+export const saveAddress = createAsyncThunk(
+  'order/saveAddress',
+  async (address, { rejectWithValue, extra }) => {
+    try {
+      const { axiosInstance } = extra
+      const { data } = await axiosInstance.post('/addresses', address)
+      return data
+    } catch (error) {
+      return rejectWithValue(error.response?.data || error.message)
+    }
+  }
+)
+
 export const createOrder = createAsyncThunk(
   'cart/createOrder',
-  async (address, { getState, rejectWithValue, extra }) => {
+  async ({ addressId, expectedTotal }, { getState, rejectWithValue, extra }) => {
     try {
       const { axiosInstance } = extra // Получаем axiosInstance из extraArgument
+      // Заказ оформляется на адрес из адресной книги
       const { data } = await axiosInstance.post('/order/create', {
-        address: address,
+        address_id: addressId,
+        // Подтверждаем сумму, которую видел пользователь
+        expected_total: expectedTotal,
       }) // Используем относительный путь
       return data
     } catch (error) {
@@ -15,6 +45,32 @@ export const createOrder = createAsyncThunk(
   }
 )
 
+// Оплачивает созданный заказ. payment — платёж из ответа на создание заказа;
+// если его нет, платёж создаётся заново. Встроенный fake-провайдер
+// подтверждает оплату сразу, реальный провайдер подключается здесь же
+export const payOrder = createAsyncThunk(
+  'order/payOrder',
+  async ({ orderNumber, payment }, { rejectWithValue, extra }) => {
+    try {
+      const { axiosInstance } = extra
+      let intent = payment
+      if (!intent) {
+        const { data } = await axiosInstance.post(`/order/${orderNumber}/pay`)
+        intent = data
+      }
+      if (intent.provider !== 'fake') {
+        return rejectWithValue({ error: `Неизвестный провайдер оплаты: ${intent.provider}` })
+      }
+      const { data } = await axiosInstance.post(
+        `/payments/fake/${intent.intent_id}/authorize`
+      )
+      return data
+    } catch (error) {
+      return rejectWithValue(error.response?.data || error.message)
+    }
+  }
+)
+
 export const getAll = createAsyncThunk(
   'cart/getAll',
   async (_, { getState, rejectWithValue, extra }) => {
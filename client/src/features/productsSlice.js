@@ -1,17 +1,63 @@
 import { createSlice, createAsyncThunk } from '@reduxjs/toolkit'
-import { fetchProducts } from '../api/products'
+import { fetchProducts, fetchProductById } from '../api/products'
 
+// Фильтры и сортировка применяются на сервере, чтобы страницы по курсору
+// шли в том же порядке
+const productQuery = ({ category, sortBy, searchQuery }) => {
+  const params = {}
+  if (category !== 'Все') params.category = category
+  if (searchQuery.trim()) params.q = searchQuery.trim()
+  switch (sortBy) {
+    case 'priceAsc':
+      params.sort = 'price'
+      params.order = 'asc'
+      break
+    case 'priceDesc':
+      params.sort = 'price'
+      params.order = 'desc'
+      break
+    case 'rating':
+      params.sort = 'rating'
+      params.order = 'desc'
+      break
+  }
+  return params
+}
+
+// Загружает первую страницу товаров с текущими фильтрами
 export const loadProducts = createAsyncThunk(
   'products/loadProducts',
-  async () => {
-    const products = await fetchProducts()
-    return products
+  async (_, { getState }) => {
+    return fetchProducts(productQuery(getState().products))
+  }
+)
+
+// Загружает один товар: на странице товара он может быть не из первой страницы
+export const loadProduct = createAsyncThunk(
+  'products/loadProduct',
+  async (id) => fetchProductById(id)
+)
+
+// Догружает следующую страницу по next_cursor
+export const loadMoreProducts = createAsyncThunk(
+  'products/loadMoreProducts',
+  async (_, { getState }) => {
+    const state = getState().products
+    return fetchProducts({ ...productQuery(state), cursor: state.nextCursor })
+  },
+  {
+    condition: (_, { getState }) => {
+      const { nextCursor, loadingMore } = getState().products
+      return Boolean(nextCursor) && !loadingMore
+    },
   }
 )
 const productsSlice = createSlice({
   name: 'products',
   initialState: {
     items: [],
+    nextCursor: '',
+    loadingMore: false,
     status: 'idle',
     error: null,
     category: 'Все',
@@ -36,12 +82,33 @@ const productsSlice = createSlice({
       })
       .addCase(loadProducts.fulfilled, (state, action) => {
         state.status = 'succeeded'
-        state.items = action.payload
+        state.items = action.payload.items
+        state.nextCursor = action.payload.nextCursor
       })
       .addCase(loadProducts.rejected, (state, action) => {
         state.status = 'failed'
         state.error = action.error.message
       })
+      .addCase(loadProduct.fulfilled, (state, action) => {
+        const index = state.items.findIndex((p) => p.id === action.payload.id)
+        if (index === -1) {
+          state.items.push(action.payload)
+        } else {
+          state.items[index] = { ...state.items[index], ...action.payload }
+        }
+      })
+      .addCase(loadMoreProducts.pending, (state) => {
+        state.loadingMore = true
+      })
+      .addCase(loadMoreProducts.fulfilled, (state, action) => {
+        state.loadingMore = false
+        state.items = [...state.items, ...action.payload.items]
+        state.nextCursor = action.payload.nextCursor
+      })
+      .addCase(loadMoreProducts.rejected, (state, action) => {
+        state.loadingMore = false
+        state.error = action.error.message
+      })
   },
 })
 export const { setCategory, setSortBy, setSearchQuery } = productsSlice.actions
@@ -11,9 +11,15 @@ import {
   Checkbox,
   Alert,
   CircularProgress,
+  MenuItem,
 } from '@mui/material'
 import { BackToStoreBanner } from '../components/BackToStore'
-import { createOrder } from '../features/orderSlice'
+import {
+  createOrder,
+  fetchAddresses,
+  payOrder,
+  saveAddress,
+} from '../features/orderSlice'
 import { clearCart, fetchCart } from '../features/cartSlice'
 import axios from 'axios'
 
@@ -23,9 +29,11 @@ const CheckoutPage = () => {
   const { items, total } = useSelector((state) => state.cart)
   const [formData, setFormData] = useState({
     name: '',
+    phone: '',
     address: '',
     city: '',
     postalCode: '',
+    apartment: '',
     cardNumber: '',
     expDate: '',
     cvv: '',
@@ -34,15 +42,37 @@ const CheckoutPage = () => {
   const [errors, setErrors] = useState({})
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
+  // Адреса из адресной книги; 'new' — ввести новый адрес в форме
+  const [addresses, setAddresses] = useState([])
+  const [addressId, setAddressId] = useState('new')
+
+  useEffect(() => {
+    dispatch(fetchAddresses())
+      .unwrap()
+      .then((saved) => {
+        setAddresses(saved)
+        const preferred = saved.find((address) => address.is_default) || saved[0]
+        if (preferred) setAddressId(preferred.id)
+      })
+      .catch(() => {
+        // Без адресной книги адрес просто вводится в форме
+      })
+  }, [dispatch])
 
   const validateForm = () => {
     const newErrors = {}
-    if (
-      !formData.address.match(
-        /^[а-яА-Яa-zA-Z\s-]+,\s*[а-яА-Яa-zA-Z\s-]+,\s*(?:[а-яА-Яa-zA-Z0-9\s-.]*[а-яА-Яa-zA-Z0-9]+)+,\s*[а-яА-Яa-zA-Z0-9\/-]+$/
+    if (addressId === 'new') {
+      if (!formData.name.trim()) newErrors.name = 'Required'
+      if (!formData.phone.match(/^\+?[\d\s()-]{5,20}$/))
+        newErrors.phone = 'Invalid phone'
+      if (
+        !formData.address.match(
+          /^[а-яА-Яa-zA-Z\s-]+,\s*[а-яА-Яa-zA-Z\s-]+,\s*(?:[а-яА-Яa-zA-Z0-9\s-.]*[а-яА-Яa-zA-Z0-9]+)+,\s*[а-яА-Яa-zA-Z0-9\/-]+$/
+        )
       )
-    )
-      newErrors.address = 'Invalid address'
+        newErrors.address = 'Invalid address'
+      if (!formData.postalCode) newErrors.postalCode = 'Required'
+    }
     if (!formData.cardNumber.match(/^\d{4}\s\d{4}\s\d{4}\s\d{4}$/))
       newErrors.cardNumber = 'Invalid card number'
     if (!formData.expDate.match(/^(0[1-9]|1[0-2])\/?([0-9]{2})$/))
@@ -59,14 +89,50 @@ const CheckoutPage = () => {
 
     setLoading(true)
     try {
-      const orderData = {
-        address: formData.address,
+      let orderAddressId = addressId
+      if (orderAddressId === 'new') {
+        const [country, city, street, building] = formData.address
+          .split(',')
+          .map((part) => part.trim())
+        const saved = await dispatch(
+          saveAddress({
+            recipient_name: formData.name,
+            phone: formData.phone,
+            country,
+            city,
+            street,
+            building,
+            apartment: formData.apartment,
+            postal_code: formData.postalCode,
+          })
+        ).unwrap()
+        // Выбираем сохранённый адрес, чтобы повторная попытка не создала его снова
+        setAddresses((prev) => [...prev, saved])
+        setAddressId(saved.id)
+        orderAddressId = saved.id
       }
 
-      await dispatch(createOrder(formData.address)).unwrap()
+      const order = await dispatch(
+        createOrder({ addressId: orderAddressId, expectedTotal: total })
+      ).unwrap()
       dispatch(fetchCart())
+      try {
+        await dispatch(
+          payOrder({ orderNumber: order.order_number, payment: order.payment })
+        ).unwrap()
+      } catch (payErr) {
+        // Заказ уже создан, ошибка оплаты его не отменяет
+        setError(payErr?.error || 'Оплата не прошла, заказ сохранён без оплаты')
+        return
+      }
       navigate('/success', { state: { orderSuccess: true } })
     } catch (err) {
+      if (err?.warnings) {
+        // Цены или наличие изменились: показываем новую сумму для подтверждения
+        dispatch(fetchCart())
+        setError(`Корзина изменилась, новая сумма: ${err.totals?.total}`)
+        return
+      }
       setError(err.response?.data?.message || 'Order processing failed')
     } finally {
       setLoading(false)
@@ -136,18 +202,92 @@ const CheckoutPage = () => {
               </Typography>
             </Grid>
 
-            <Grid item xs={12}>
-              <TextField
-                fullWidth
-                label='Адрес (Страна, город, улица, дом)'
-                name='address'
-                value={formData.address}
-                onChange={handleChange}
-                error={!!errors.address}
-                helperText={errors.address}
-                required
-              />
-            </Grid>
+            {addresses.length > 0 && (
+              <Grid item xs={12}>
+                <TextField
+                  select
+                  fullWidth
+                  label='Адрес из адресной книги'
+                  value={addressId}
+                  onChange={(e) => setAddressId(e.target.value)}
+                >
+                  {addresses.map((address) => (
+                    <MenuItem key={address.id} value={address.id}>
+                      {address.recipient_name}, {address.city}, {address.street},{' '}
+                      {address.building}
+                    </MenuItem>
+                  ))}
+                  <MenuItem value='new'>Новый адрес</MenuItem>
+                </TextField>
+              </Grid>
+            )}
+
+            {addressId === 'new' && (
+              <>
+                <Grid item xs={12} sm={6}>
+                  <TextField
+                    fullWidth
+                    label='Получатель'
+                    name='name'
+                    value={formData.name}
+                    onChange={handleChange}
+                    error={!!errors.name}
+                    helperText={errors.name}
+                    required
+                  />
+                </Grid>
+
+                <Grid item xs={12} sm={6}>
+                  <TextField
+                    fullWidth
+                    label='Телефон'
+                    name='phone'
+                    value={formData.phone}
+                    onChange={handleChange}
+                    error={!!errors.phone}
+                    helperText={errors.phone}
+                    required
+                  />
+                </Grid>
+
+                <Grid item xs={12}>
+                  <TextField
+                    fullWidth
+                    label='Адрес (Страна, город, улица, дом)'
+                    name='address'
+                    value={formData.address}
+                    onChange={handleChange}
+                    error={!!errors.address}
+                    helperText={errors.address}
+                    required
+                  />
+                </Grid>
+
+                <Grid item xs={12} sm={6}>
+                  <TextField
+                    fullWidth
+                    label='Квартира'
+                    name='apartment'
+                    value={formData.apartment}
+                    onChange={handleChange}
+                  />
+                </Grid>
+
+                <Grid item xs={12} sm={6}>
+                  <TextField
+                    fullWidth
+                    label='Индекс'
+                    name='postalCode'
+                    value={formData.postalCode}
+                    onChange={handleChange}
+                    inputProps={{ maxLength: 6 }}
+                    error={!!errors.postalCode}
+                    helperText={errors.postalCode}
+                    required
+                  />
+                </Grid>
+              </>
+            )}
 
             <Grid item xs={12}>
               <Typography variant='h6' gutterBottom>
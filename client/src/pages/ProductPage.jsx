@@ -24,7 +24,8 @@ import {
   fetchUserFeedback,
   updateFeedback,
 } from '../features/feedbackSlice'
-import { loadProducts } from '../features/productsSlice'
+import { loadProduct } from '../features/productsSlice'
+import { imageUrl } from '../api/images'
 
 const ProductPage = () => {
   const { id } = useParams()
@@ -34,11 +35,10 @@ const ProductPage = () => {
   const [comment, setComment] = useState('')
   const [rating, setRating] = useState(5)
   const [isEditing, setIsEditing] = useState(false)
+  const [productStatus, setProductStatus] = useState('loading')
 
   // Получаем данные из Redux store
-  const { items: products, status: productsStatus } = useSelector(
-    (state) => state.products
-  )
+  const products = useSelector((state) => state.products.items)
   const feedbacks = useSelector((state) => state.feedback.items)
   const feedbacksStatus = useSelector((state) => state.feedback.status)
   const userFeedback = useSelector((state) => state.feedback.userFeedback)
@@ -47,12 +47,14 @@ const ProductPage = () => {
   const product = products.find((p) => p.id === parseInt(id))
 
   useEffect(() => {
-    if (productsStatus === 'idle') {
-      dispatch(loadProducts())
-    }
+    setProductStatus('loading')
+    dispatch(loadProduct(id))
+      .unwrap()
+      .then(() => setProductStatus('succeeded'))
+      .catch(() => setProductStatus('failed'))
     dispatch(fetchUserFeedback(id))
     dispatch(fetchFeedbacks(id))
-  }, [dispatch, id, productsStatus])
+  }, [dispatch, id])
 
   // Сброс формы при переключении режима редактирования
   useEffect(() => {
@@ -91,7 +93,7 @@ const ProductPage = () => {
         setComment('')
         setRating(5)
       }
-      dispatch(loadProducts()) // Обновляем рейтинг продукта
+      dispatch(loadProduct(id)) // Обновляем рейтинг продукта
     } catch (err) {
       console.error('Failed to submit feedback:', err)
     }
@@ -112,7 +114,10 @@ const ProductPage = () => {
     }
   }
 
-  if (productsStatus === 'loading' || feedbacksStatus === 'loading') {
+  if (
+    (!product && productStatus === 'loading') ||
+    feedbacksStatus === 'loading'
+  ) {
     return <div>Loading...</div>
   }
 
@@ -130,7 +135,7 @@ const ProductPage = () => {
             <CardMedia
               component='img'
               height='400'
-              image={product.image}
+              image={imageUrl(product.image, { height: 800 })}
               alt={product.title}
               sx={{ objectFit: 'contain' }}
             />
@@ -14,7 +14,7 @@ import {
   Alert,
   Grid,
 } from '@mui/material'
-import { getProfile, logout, updateUser } from '../features/authSlice'
+import { getProfile, logoutUser, updateUser } from '../features/authSlice'
 import { getAll } from '../features/orderSlice'
 import { BackToStoreBanner } from '../components/BackToStore'
 import axios from 'axios'
@@ -57,8 +57,8 @@ const ProfilePage = () => {
   }, [token])
 
   // Обработка выхода
-  const handleLogout = () => {
-    dispatch(logout())
+  const handleLogout = async () => {
+    await dispatch(logoutUser())
     navigate('/auth')
   }
 
@@ -7,11 +7,12 @@ import orderReducer from '../features/orderSlice'
 import cartReducer from '../features/cartSlice'
 import axios from 'axios'
 import feedbackReducer from '../features/feedbackSlice'
-import { logout } from '../features/authSlice'
+import { logout, setCredentials } from '../features/authSlice'
 
 // Настройка axios
 const axiosInstance = axios.create({
   baseURL: 'http://localhost:8080/api',
+  withCredentials: true,
 })
 
 // Добавляем токен в заголовки
@@ -23,14 +24,53 @@ axiosInstance.interceptors.request.use((config) => {
   return config
 })
 
+// Обновление access-токена. Параллельные запросы, получившие 401, ждут
+// одного общего обновления: при ротации второй запрос с тем же
+// refresh-токеном получил бы отказ и разлогинил пользователя
+let refreshPromise = null
+
+const refreshAccessToken = (refreshToken) => {
+  if (!refreshPromise) {
+    refreshPromise = axios
+      .post('http://localhost:8080/api/auth/refresh', {
+        refresh_token: refreshToken,
+      })
+      .then(({ data }) => {
+        localStorage.setItem('token', data.token)
+        localStorage.setItem('refreshToken', data.refresh_token)
+        store.dispatch(setCredentials(data.token))
+        return data.token
+      })
+      .finally(() => {
+        refreshPromise = null
+      })
+  }
+  return refreshPromise
+}
+
 // Обработка 401 ошибки (истечение токена)
 axiosInstance.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
     if (error.config.skipInterceptors) {
       return Promise.reject(error)
     }
     if (error.response?.status === 401) {
+      // Пробуем один раз обновить access-токен по refresh-токену
+      const refreshToken = localStorage.getItem('refreshToken')
+      if (refreshToken && !error.config.retried) {
+        const token = localStorage.getItem('token')
+        const sentToken = error.config.headers?.Authorization
+        try {
+          // Если токен уже обновил другой запрос, просто повторяем с новым
+          if (refreshPromise || !token || sentToken === `Bearer ${token}`) {
+            await refreshAccessToken(refreshToken)
+          }
+          return axiosInstance({ ...error.config, retried: true })
+        } catch {
+          // refresh-токен недействителен — выходим
+        }
+      }
       store.dispatch(logout())
       window.location.href = '/auth' // Перенаправление на страницу входа
     }
@@ -1,7 +1,13 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"kotoshop/models"
+	"kotoshop/postgres"
 	"log"
 	"net/http"
 	"os"
@@ -10,13 +16,27 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
 )
 
-func createAccessToken(userId uint) (string, error) {
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+type accessClaims struct {
+	UserID    uint
+	SessionID uint
+	Role      string
+}
+
+func createAccessToken(userId uint, sessionId uint, role string) (string, error) {
 	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub": userId,                    // Subject (user identifier)
+		"sid": sessionId,                 // Session (refresh token) identifier
+		"role": role,                     // User role
 		"iss": "todo-app",                  // Issuer
-		"exp": time.Now().Add(time.Hour*24*30).Unix(), // Expiration time
+		"exp": time.Now().Add(accessTokenTTL).Unix(), // Expiration time
 		"iat": time.Now().Unix(),                 // Issued at
 })
 
@@ -30,9 +50,51 @@ func createAccessToken(userId uint) (string, error) {
 	return tokenString, nil
 }
 
-func checkToken(tokenString string) (uint, error) {
+// createRefreshToken возвращает случайный refresh-токен и его хэш.
+// В базе хранится только хэш, сам токен отдаётся клиенту один раз.
+func createRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession заводит новую сессию пользователя и выдаёт пару токенов.
+func createSession(db *gorm.DB, userID uint, role string) (string, string, error) {
+	refreshToken, refreshHash, err := createRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	session := models.Session{
+		UserID: userID,
+		RefreshTokenHash: refreshHash,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+
+	if err := db.Create(&session).Error; err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := createAccessToken(userID, session.ID, role)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func checkToken(tokenString string) (accessClaims, error) {
 	if tokenString == "" {
-			return 0, fmt.Errorf("токен отсутствует")
+			return accessClaims{}, fmt.Errorf("токен отсутствует")
 	}
 
 	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
@@ -43,30 +105,42 @@ func checkToken(tokenString string) (uint, error) {
 	})
 
 	if err != nil {
-			return 0, fmt.Errorf("ошибка валидации токена: %v", err)
+			return accessClaims{}, fmt.Errorf("ошибка валидации токена: %v", err)
 	}
 
 	if !token.Valid {
-			return 0, fmt.Errorf("невалидный токен")
+			return accessClaims{}, fmt.Errorf("невалидный токен")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-			return 0, fmt.Errorf("ошибка разбора claims")
+			return accessClaims{}, fmt.Errorf("ошибка разбора claims")
 	}
 
 	// Проверка exp
 	if exp, ok := claims["exp"].(float64); !ok || float64(time.Now().Unix()) > exp {
-			return 0, fmt.Errorf("токен истёк")
+			return accessClaims{}, fmt.Errorf("токен истёк")
 	}
 
 	// Проверка sub
 	sub, ok := claims["sub"].(float64)
 	if !ok || sub == 0 {
-			return 0, fmt.Errorf("токен не содержит id пользователя")
+			return accessClaims{}, fmt.Errorf("токен не содержит id пользователя")
+	}
+
+	// Проверка sid
+	sid, ok := claims["sid"].(float64)
+	if !ok || sid == 0 {
+			return accessClaims{}, fmt.Errorf("токен не содержит id сессии")
+	}
+
+	// Токены без роли считаем выданными покупателю
+	role, _ := claims["role"].(string)
+	if role == "" {
+			role = models.RoleCustomer
 	}
 
-	return uint(sub), nil
+	return accessClaims{UserID: uint(sub), SessionID: uint(sid), Role: role}, nil
 }
 
 func AuthMiddleware(c *gin.Context) {
@@ -78,7 +152,7 @@ func AuthMiddleware(c *gin.Context) {
 	}
 
 	token := strings.TrimSpace(strings.TrimPrefix(tokenString, "Bearer "))
-	userID, err := checkToken(token)
+	claims, err := checkToken(token)
 
 	if err != nil {
 		log.Printf("ошибка валидации токена: %s", err.Error())
@@ -86,7 +160,34 @@ func AuthMiddleware(c *gin.Context) {
 			return
 	}
 
-	c.Set("userID", userID)
+	// Отозванная или истёкшая сессия делает недействительными и её access-токены
+	var session models.Session
+	if err := postgres.DB.Select("id").Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", claims.SessionID, claims.UserID, time.Now()).First(&session).Error; err != nil {
+		log.Printf("сессия %d недействительна: %v", claims.SessionID, err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "сессия недействительна"})
+		return
+	}
+
+	c.Set("userID", claims.UserID)
+	c.Set("sessionID", claims.SessionID)
+	c.Set("role", claims.Role)
 	c.Next()
 }
 
+// RequireRole пропускает запрос только если роль пользователя входит в roles.
+// Должен стоять после AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		log.Printf("пользователю %d с ролью %q запрещён доступ к %s", c.GetUint("userID"), role, c.FullPath())
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "недостаточно прав"})
+	}
+}
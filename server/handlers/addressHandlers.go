@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// saveAddress сохраняет адрес и следит, чтобы у пользователя был ровно один
+// адрес по умолчанию: первый адрес становится им автоматически
+func saveAddress(tx *gorm.DB, address *models.Address) error {
+	var count int64
+	if err := tx.Model(&models.Address{}).Where("user_id = ? AND id <> ?", address.UserID, address.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		address.IsDefault = true
+	}
+
+	if address.IsDefault {
+		if err := tx.Model(&models.Address{}).Where("user_id = ? AND id <> ? AND is_default", address.UserID, address.ID).Update("is_default", false).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Save(address).Error
+}
+
+// GetAddresses godoc
+// @Summary      Возвращает адреса
+// @Description  Возвращает адресную книгу пользователя, адрес по умолчанию первым
+// @Tags         Address
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/addresses [get]
+func GetAddresses(c *gin.Context) {
+	addresses := []models.Address{}
+
+	if err := postgres.DB.Where("user_id = ?", c.GetUint("userID")).Order("is_default DESC, id DESC").Find(&addresses).Error; err != nil {
+		log.Printf("error on getting addresses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting addresses",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"addresses": addresses,
+	})
+}
+
+// CreateAddress godoc
+// @Summary      Добавляет адрес
+// @Description  Добавляет адрес в адресную книгу пользователя. Первый адрес становится адресом по умолчанию
+// @Tags         Address
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param address body models.RequestAddress true "Адрес"
+// @Success      201  {object}  models.Address
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/addresses [post]
+func CreateAddress(c *gin.Context) {
+	var req models.RequestAddress
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("error on parsing address: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid address data",
+		})
+		return
+	}
+
+	address := models.Address{
+		UserID: c.GetUint("userID"),
+		AddressFields: req.Fields(),
+		IsDefault: req.IsDefault,
+	}
+
+	if err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		return saveAddress(tx, &address)
+	}); err != nil {
+		log.Printf("error on creating address: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on creating address",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, address)
+}
+
+// UpdateAddress godoc
+// @Summary      Обновляет адрес
+// @Description  Полностью заменяет поля адреса. Уже оформленные заказы хранят свой снимок адреса и не меняются
+// @Tags         Address
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID адреса"
+// @Param address body models.RequestAddress true "Адрес"
+// @Success      200  {object}  models.Address
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/addresses/{id} [put]
+func UpdateAddress(c *gin.Context) {
+	var req models.RequestAddress
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("error on parsing address: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid address data",
+		})
+		return
+	}
+
+	var address models.Address
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("userID")).First(&address).Error; err != nil {
+			return err
+		}
+
+		// Снять признак по умолчанию можно, только выбрав другой адрес
+		address.AddressFields = req.Fields()
+		address.IsDefault = address.IsDefault || req.IsDefault
+
+		return saveAddress(tx, &address)
+	})
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "address not found",
+			})
+			return
+		}
+
+		log.Printf("error on updating address: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on updating address",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, address)
+}
+
+// DeleteAddress godoc
+// @Summary      Удаляет адрес
+// @Description  Удаляет адрес из адресной книги. Если он был адресом по умолчанию, им становится последний добавленный
+// @Tags         Address
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID адреса"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/addresses/{id} [delete]
+func DeleteAddress(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var address models.Address
+		if err := tx.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&address).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&address).Error; err != nil {
+			return err
+		}
+
+		if !address.IsDefault {
+			return nil
+		}
+
+		var next models.Address
+		if err := tx.Where("user_id = ?", userID).Order("id DESC").First(&next).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		// Удалённый адрес всё ещё держит признак, но частичный индекс его уже не учитывает
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "address not found",
+			})
+			return
+		}
+
+		log.Printf("error on deleting address: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting address",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "address deleted successfully",
+	})
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetCategoryAttributes godoc
+// @Summary      Возвращает характеристики категории
+// @Description  Возвращает характеристики вариантов товаров категории вместе с унаследованными от родительских категорий
+// @Tags         Categories
+// @Accept       json
+// @Produce      json
+// @Param id path uint true "ID категории"
+// @Param lang query string false "Язык названий (ru, en), по умолчанию из Accept-Language"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/categories/{id}/attributes [get]
+func GetCategoryAttributes(c *gin.Context) {
+	var category models.Category
+
+	if err := postgres.DB.First(&category, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "category not found",
+		})
+		return
+	}
+
+	definitions, err := categoryAttributes(postgres.DB, &category.ID)
+	if err != nil {
+		log.Printf("error on getting category attributes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting category attributes",
+		})
+		return
+	}
+
+	lang := requestLanguage(c)
+	for i := range definitions {
+		definitions[i].Name = definitions[i].LocalizedName(lang)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"attributes": definitions,
+	})
+}
+
+// CreateAttribute godoc
+// @Summary      Создаёт характеристику категории
+// @Description  Добавляет типизированную характеристику (string, number, boolean, enum) вариантам товаров категории и её подкатегорий
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID категории"
+// @Param attribute body models.RequestAttributeDefinition true "Характеристика"
+// @Success      201  {object}  models.AttributeDefinition
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/categories/{id}/attributes [post]
+func CreateAttribute(c *gin.Context) {
+	var category models.Category
+
+	if err := postgres.DB.First(&category, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "category not found",
+		})
+		return
+	}
+
+	definition := models.AttributeDefinition{CategoryID: category.ID}
+	if !bindAttribute(c, &definition) {
+		return
+	}
+
+	if err := postgres.DB.Create(&definition).Error; err != nil {
+		log.Printf("error on creating attribute: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on creating attribute",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, definition)
+}
+
+// UpdateAttribute godoc
+// @Summary      Обновляет характеристику категории
+// @Description  Полностью заменяет характеристику. Уже сохранённые значения вариантов не перепроверяются
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID характеристики"
+// @Param attribute body models.RequestAttributeDefinition true "Характеристика"
+// @Success      200  {object}  models.AttributeDefinition
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/attributes/{id} [put]
+func UpdateAttribute(c *gin.Context) {
+	var definition models.AttributeDefinition
+
+	if err := postgres.DB.First(&definition, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "attribute not found",
+		})
+		return
+	}
+
+	if !bindAttribute(c, &definition) {
+		return
+	}
+
+	if err := postgres.DB.Select("code", "names", "type", "options", "required", "sort_order").Updates(&definition).Error; err != nil {
+		log.Printf("error on updating attribute: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on updating attribute",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, definition)
+}
+
+// DeleteAttribute godoc
+// @Summary      Удаляет характеристику категории
+// @Description  Удаляет характеристику. Значения у вариантов остаются, но перестают показываться в матрице вариантов
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID характеристики"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/attributes/{id} [delete]
+func DeleteAttribute(c *gin.Context) {
+	result := postgres.DB.Delete(&models.AttributeDefinition{}, c.Param("id"))
+
+	if result.Error != nil {
+		log.Printf("error on deleting attribute: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting attribute",
+		})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "attribute not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "attribute deleted successfully",
+	})
+}
+
+// bindAttribute разбирает характеристику из запроса в definition и проверяет её.
+// При ошибке сам отвечает клиенту и возвращает false.
+func bindAttribute(c *gin.Context, definition *models.AttributeDefinition) bool {
+	var req models.RequestAttributeDefinition
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("error on parsing attribute: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid attribute data",
+		})
+		return false
+	}
+
+	code := strings.ToLower(strings.TrimSpace(req.Code))
+	if code == "" || strings.ContainsAny(code, " \t") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code must be a single word"})
+		return false
+	}
+
+	if req.Type == models.AttributeTypeEnum && len(req.Options) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enum attribute needs options"})
+		return false
+	}
+	if req.Type != models.AttributeTypeEnum {
+		req.Options = nil
+	}
+
+	var duplicate models.AttributeDefinition
+	err := postgres.DB.Where("category_id = ? AND code = ? AND id <> ?", definition.CategoryID, code, definition.ID).First(&duplicate).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "attribute code already exists in category"})
+		return false
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("error on checking attribute code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on checking attribute code"})
+		return false
+	}
+
+	definition.Code = code
+	definition.Names = req.Names
+	definition.Type = req.Type
+	definition.Options = req.Options
+	definition.Required = req.Required
+	definition.SortOrder = req.SortOrder
+
+	return true
+}
@@ -1,19 +1,23 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"kotoshop/models"
 	"kotoshop/postgres"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Signup godoc
 // @Summary      Регистрирует нового пользователя
-// @Description  Регистрирует пользователя через почту и пароль
+// @Description  Регистрирует пользователя через почту и пароль. Гостевая корзина из cookie cart_token или X-Cart-Token переносится в корзину пользователя
 // @Tags         Auth
 // @Accept       json
 // @Produce      json
@@ -47,6 +51,7 @@ func Signup(c *gin.Context) {
 	}
 
 	user.Password = string(userPassword)
+	user.Role = models.RoleCustomer
 
 	if err := postgres.DB.Create(&user).Error; err != nil {
     log.Print("Ошибка при создании пользователя:", err) 
@@ -57,9 +62,12 @@ func Signup(c *gin.Context) {
     return
 }
 
-	accessToken, accessErr := createAccessToken(user.ID)
+	mergeGuestCart(c, user.ID)
+
+	accessToken, refreshToken, accessErr := createSession(postgres.DB, user.ID, user.Role)
 
 	if accessErr != nil {
+		log.Printf("error on creating session: %v", accessErr)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "error on creating token",
 		})
@@ -70,13 +78,15 @@ func Signup(c *gin.Context) {
 	c.JSON(http.StatusAccepted, gin.H{
 		"message": "user successfully signed up",
 		"token": accessToken,
+		"refresh_token": refreshToken,
+		"expires_in": int(accessTokenTTL.Seconds()),
 	})
 }
 
 
 // Login godoc
 // @Summary      Аутентифицирует пользователя
-// @Description  Аутентифицирует пользователя через почту и пароль
+// @Description  Аутентифицирует пользователя через почту и пароль. Гостевая корзина из cookie cart_token или X-Cart-Token переносится в корзину пользователя
 // @Tags         Auth
 // @Accept       json
 // @Produce      json
@@ -111,9 +121,12 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	accessToken, accessErr := createAccessToken(foundUser.ID)
+	mergeGuestCart(c, foundUser.ID)
+
+	accessToken, refreshToken, accessErr := createSession(postgres.DB, foundUser.ID, foundUser.Role)
 
 	if accessErr != nil  {
+		log.Printf("error on creating session: %v", accessErr)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "error on creating token",
 		})
@@ -124,6 +137,119 @@ func Login(c *gin.Context) {
 	c.JSON(http.StatusAccepted, gin.H{
 		"message": "user successfully signed up",
 		"token": accessToken,
+		"refresh_token": refreshToken,
+		"expires_in": int(accessTokenTTL.Seconds()),
+	})
+}
+
+// RefreshToken godoc
+// @Summary      Обновляет токены
+// @Description  Выдаёт новый access-токен и заменяет refresh-токен (старый становится недействительным)
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param token body models.RequestRefreshToken true "Refresh-токен"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/auth/refresh [post]
+func RefreshToken(c *gin.Context) {
+	var req models.RequestRefreshToken
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "refresh_token is required",
+		})
+		return
+	}
+
+	var accessToken, refreshToken string
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.Session
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashRefreshToken(req.RefreshToken), time.Now()).First(&session).Error; err != nil {
+			return err
+		}
+
+		newToken, newHash, err := createRefreshToken()
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&session).Updates(models.Session{RefreshTokenHash: newHash, ExpiresAt: time.Now().Add(refreshTokenTTL)}).Error; err != nil {
+			return err
+		}
+
+		// Роль берём из базы, чтобы её смена вступала в силу при обновлении токена
+		var user models.User
+		if err := tx.Select("id, role").First(&user, session.UserID).Error; err != nil {
+			return err
+		}
+
+		accessToken, err = createAccessToken(session.UserID, session.ID, user.Role)
+		if err != nil {
+			return err
+		}
+		refreshToken = newToken
+
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid refresh token",
+			})
+			return
+		}
+
+		log.Printf("error on refreshing token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on refreshing token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token": accessToken,
+		"refresh_token": refreshToken,
+		"expires_in": int(accessTokenTTL.Seconds()),
+	})
+}
+
+// Logout godoc
+// @Summary      Завершает сессию
+// @Description  Отзывает текущую сессию пользователя, с all=true — все его сессии
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param all query bool false "Завершить все сессии пользователя"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/auth/logout [post]
+func Logout(c *gin.Context) {
+	userID := c.GetUint("userID")
+	sessionID := c.GetUint("sessionID")
+
+	query := postgres.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if c.Query("all") != "true" {
+		query = query.Where("id = ?", sessionID)
+	}
+
+	if err := query.Update("revoked_at", time.Now()).Error; err != nil {
+		log.Printf("error on revoking session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on revoking session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "user successfully logged out",
 	})
 }
 
@@ -143,7 +269,7 @@ func Profile(c *gin.Context) {
 	userID := c.GetUint("userID")
 
 	var user models.User 
-	if err := postgres.DB.Select("email, first_name, last_name, phone_number").First(&user, userID).Error; err != nil {
+	if err := postgres.DB.Select("email, first_name, last_name, phone_number, role").First(&user, userID).Error; err != nil {
 		log.Printf("error on selecting database: %v", err )
 		c.AbortWithStatus(http.StatusUnauthorized)
 
@@ -155,6 +281,7 @@ func Profile(c *gin.Context) {
 		"first_name": user.FirstName,
 		"last_name":user.LastName,
 		"phone_number":user.PhoneNumber,
+		"role":user.Role,
 	})
 }
 
@@ -196,4 +323,51 @@ func UpdateUser (c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message":"user updated successfully",
 	})
-}
\ No newline at end of file
+}
+
+// UpdateUserRole godoc
+// @Summary      Меняет роль пользователя
+// @Description  Назначает пользователю роль customer, manager или admin. Только для администраторов
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID пользователя"
+// @Param role body models.RequestUpdateRole true "Новая роль"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/users/{id}/role [put]
+func UpdateUserRole(c *gin.Context) {
+	var req models.RequestUpdateRole
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "role must be one of customer, manager, admin",
+		})
+		return
+	}
+
+	var user models.User
+
+	if err := postgres.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "user not found",
+		})
+		return
+	}
+
+	if err := postgres.DB.Model(&user).Update("role", req.Role).Error; err != nil {
+		log.Printf("error on updating user role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on updating user role",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "user role updated successfully",
+	})
+}
@@ -6,27 +6,29 @@ import (
 	"kotoshop/postgres"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // AddToCart godoc
 // @Summary      Добавляет продукты в корзину
-// @Description  Добавляет новые продукты в корзину пользователя
+// @Description  Добавляет новые продукты в корзину пользователя. Без variant_id добавляется вариант товара по умолчанию
 // @Tags         Cart
 // @Accept       json
 // @Produce      json
 // @Param item body models.RequestCartItem true "Данные товара"
-// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /api/cart/add_product [post]
 func AddToCart(c *gin.Context) {
-	userID := c.GetUint("userID")
-
 	var req models.RequestCartItem
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,24 +41,28 @@ func AddToCart(c *gin.Context) {
 
 	var cart models.Cart 
 
-	if err := postgres.DB.Preload("Items.Product").FirstOrCreate(&cart, models.Cart{UserID: userID}).Error; err != nil {
+	if err := postgres.DB.FirstOrCreate(&cart, cartOwner(c)).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "error on getting user's cart",
 		})
 		return 
 	}
 
-	var product models.Product 
-
-	if err := postgres.DB.First(&product, req.ProductID).Error; err != nil {
+	variant, err := resolveVariant(postgres.DB, req.ProductID, req.VariantID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
-			"error":"product not found",
+			"error":err.Error(),
 		})
 		return 
 	}
 
 	var item models.CartItem
-	if err := postgres.DB.Where("cart_id = ? AND product_id = ?", cart.ID, product.ID).First(&item).Error; err == nil {
+	if err := postgres.DB.Where("cart_id = ? AND variant_id = ?", cart.ID, variant.ID).First(&item).Error; err == nil {
+		if item.Quantity+req.Quantity > variant.Stock {
+			respondNotEnoughStock(c, variant)
+			return
+		}
+
 		if result := postgres.DB.Model(&item).Update("quantity", item.Quantity+req.Quantity).Error; result != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":"error on adding cart products",
@@ -65,11 +71,17 @@ func AddToCart(c *gin.Context) {
 		}
 	} else {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if req.Quantity > variant.Stock {
+				respondNotEnoughStock(c, variant)
+				return
+			}
+
 			item = models.CartItem{
 				CartID: cart.ID,
-				ProductID: product.ID,
-				Quantity: 1,
-				Price: float64(product.Price),
+				ProductID: variant.ProductID,
+				VariantID: variant.ID,
+				Quantity: req.Quantity,
+				Price: variant.Price,
 			}
 	
 			if result := postgres.DB.Create(&item).Error; result != nil {
@@ -86,15 +98,6 @@ func AddToCart(c *gin.Context) {
 		}
 	}
 
-	cart.Total = cart.Total + item.Price
-
-	if err := postgres.DB.Save(&cart).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":"error on updating user cart total",
-		})
-		return 
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"message":"user's cart products added successfully",
 	})
@@ -102,77 +105,70 @@ func AddToCart(c *gin.Context) {
 
 // GetCart godoc
 // @Summary      Возвращает корзину 
-// @Description  Возвращает корзину со всеми товарами пользователя
+// @Description  Возвращает корзину со всеми товарами пользователя и суммами, посчитанными по текущим ценам. В warnings перечислены изменившиеся цены, удалённые товары и нехватка на складе
 // @Tags         Cart
 // @Accept       json
 // @Produce      json
-// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /api/cart/get_cart [get]
 func GetCart(c *gin.Context) {
-	userID := c.GetUint("userID")
-
-	if userID == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error":"user_id cannot be 0",
-		})
-		log.Print("error: userID = 0")
-		return
-	}
-
 	var cart models.Cart 
-	if err := postgres.DB.Preload("Items.Product").Where("user_id = ?", userID).FirstOrCreate(&cart, &models.Cart{UserID:userID}).Error; err != nil {
+	if err := preloadCartItems(postgres.DB).Preload("Coupon").FirstOrCreate(&cart, cartOwner(c)).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error":"error on getting user's cart",
 			})
 			return 
 		}
 
+	cart.Revalidate()
+	cart.CalculateTotals(taxRate(), shippingCost())
 	c.JSON(http.StatusOK, cart)
 }
 
-func DeleteCartItemTransaction(db *gorm.DB, item models.CartItem) error {
-	return postgres.DB.Transaction(func(tx *gorm.DB) error {
-		var cart models.Cart 
+// preloadCartItems подгружает товары и варианты позиций корзины, включая удалённые
+func preloadCartItems(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items.Product", models.WithDeletedProducts).Preload("Items.Variant", models.WithDeletedProducts)
+}
 
-		if err := tx.First(&cart, item.CartID).Error; err != nil {
-			return err
-		}
+// taxRate возвращает ставку НДС из TAX_RATE (например, 0.2), по умолчанию 0
+func taxRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("TAX_RATE"), 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
 
-		if item.Quantity == 1 {
-			if err := tx.Delete(&item).Error; err != nil {
-				return err
-			}
-			cart.Total = cart.Total - item.Price
+// shippingCost возвращает стоимость доставки из SHIPPING_COST, по умолчанию 0
+func shippingCost() float64 {
+	cost, err := strconv.ParseFloat(os.Getenv("SHIPPING_COST"), 64)
+	if err != nil || cost < 0 {
+		return 0
+	}
+	return cost
+}
 
-			if err := tx.Save(&cart).Error; err != nil {
-				return err
-			}
-			return nil
-		} else {
-			if err := tx.Model(&item).Update("quantity", item.Quantity-1).Error; err != nil {
-				return err
-			}
+func DeleteCartItemTransaction(db *gorm.DB, item models.CartItem) error {
+	if item.Quantity == 1 {
+		return db.Delete(&item).Error
+	}
 
-			cart.Total = cart.Total - item.Price
-			if err := tx.Save(&cart).Error; err != nil {
-				return err
-			}
-			return nil
-		}
-	})
+	return db.Model(&item).Update("quantity", item.Quantity-1).Error
 }
 
 // DeleteCartItem godoc
 // @Summary      Удаляем продукт корзины
-// @Description  Удаляем продукты из корзины пользователе по product_id
+// @Description  Удаляем продукты из корзины пользователе по product_id, а если передан variant_id — только этот вариант
 // @Tags         Cart
 // @Accept       json
 // @Produce      json
-// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
 // @Param ProductID body models.RequestRemoveCartItem true "Id продукта"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
@@ -180,11 +176,7 @@ func DeleteCartItemTransaction(db *gorm.DB, item models.CartItem) error {
 // @Failure      500  {object}  map[string]string
 // @Router       /api/cart/remove_product [put]
 func DeleteCartItem(c *gin.Context) {
-	userID := c.GetUint("userID")
-
-	var req struct {
-		ProductID uint `json:"product_id"`
-	}
+	var req models.RequestRemoveCartItem
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -195,7 +187,12 @@ func DeleteCartItem(c *gin.Context) {
 
 	var item models.CartItem
 
-	if err := postgres.DB.Model(&item).Joins("JOIN carts ON carts.id= cart_items.cart_id").Where("product_id = ? AND user_id = ?", req.ProductID, userID).First(&item).Error; err != nil {
+	query := postgres.DB.Where("product_id = ? AND cart_id IN (?)", req.ProductID, postgres.DB.Model(&models.Cart{}).Select("id").Where(cartOwner(c)))
+	if req.VariantID != 0 {
+		query = query.Where("variant_id = ?", req.VariantID)
+	}
+
+	if err := query.First(&item).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -206,6 +203,7 @@ func DeleteCartItem(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "error on deleting cart item",
 		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -219,18 +217,17 @@ func DeleteCartItem(c *gin.Context) {
 // @Tags         Cart
 // @Accept       json
 // @Produce      json
-// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /api/cart/clean_cart [delete]
 func CleanCart(c *gin.Context) {
-	userID := c.GetUint("userID")
-
 	var cart models.Cart 
 
-	if err := postgres.DB.Where("user_id = ?", userID).First(&cart).Error; err != nil {
+	if err := postgres.DB.Where(cartOwner(c)).First(&cart).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":"user's cart not found",
 		})
@@ -247,4 +244,229 @@ func CleanCart(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message":"user's cart deleted successfully",
 	})
-}
\ No newline at end of file
+}
+
+var errProductNotFound = errors.New("product not found")
+
+// setCartItemQuantity выставляет позиции корзины точное количество, 0 удаляет позицию.
+// Без variantID берётся вариант по умолчанию, а при удалении — все варианты товара.
+func setCartItemQuantity(tx *gorm.DB, cartID uint, productID uint, variantID uint, quantity uint) error {
+	if quantity == 0 {
+		query := tx.Where("cart_id = ? AND product_id = ?", cartID, productID)
+		if variantID != 0 {
+			query = query.Where("variant_id = ?", variantID)
+		}
+		return query.Delete(&models.CartItem{}).Error
+	}
+
+	variant, err := resolveVariant(tx, productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	if quantity > variant.Stock {
+		var product models.Product
+		if err := tx.Select("title").First(&product, variant.ProductID).Error; err != nil {
+			return err
+		}
+
+		return &outOfStockError{Items: []stockShortage{{
+			ProductID: variant.ProductID,
+			VariantID: variant.ID,
+			SKU: variant.SKU,
+			Title: product.Title,
+			Requested: quantity,
+			Available: variant.Stock,
+		}}}
+	}
+
+	var item models.CartItem
+	err = tx.Where("cart_id = ? AND variant_id = ?", cartID, variant.ID).First(&item).Error
+	if err == nil {
+		return tx.Model(&item).Update("quantity", quantity).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return tx.Create(&models.CartItem{
+		CartID: cartID,
+		ProductID: variant.ProductID,
+		VariantID: variant.ID,
+		Quantity: quantity,
+		Price: variant.Price,
+	}).Error
+}
+
+// cartItemQuantity возвращает количество варианта в корзине, 0 если позиции нет
+func cartItemQuantity(tx *gorm.DB, cartID uint, variantID uint) (uint, error) {
+	var item models.CartItem
+	if err := tx.Where("cart_id = ? AND variant_id = ?", cartID, variantID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return item.Quantity, nil
+}
+
+// updateCartLines применяет изменения позиций в одной транзакции и отвечает
+// пересчитанной корзиной
+func updateCartLines(c *gin.Context, lines []models.RequestCartLine) {
+	updateCart(c, func(tx *gorm.DB, cart models.Cart) error {
+		for _, line := range lines {
+			if err := setCartItemQuantity(tx, cart.ID, line.ProductID, line.VariantID, *line.Quantity); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// updateCart выполняет change над заблокированной корзиной в одной транзакции
+// и отвечает пересчитанной корзиной
+func updateCart(c *gin.Context, change func(tx *gorm.DB, cart models.Cart) error) {
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var cart models.Cart
+		if err := tx.FirstOrCreate(&cart, cartOwner(c)).Error; err != nil {
+			return err
+		}
+
+		// Параллельные изменения одной корзины выполняются по очереди
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cart, cart.ID).Error; err != nil {
+			return err
+		}
+
+		return change(tx, cart)
+	})
+
+	if err != nil {
+		var stockErr *outOfStockError
+
+		switch {
+		case errors.Is(err, errProductNotFound), errors.Is(err, errVariantNotFound), errors.Is(err, errNotInWishlist):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		case errors.As(err, &stockErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": stockErr.Error(),
+				"items": stockErr.Items,
+			})
+		default:
+			log.Printf("error on updating cart: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error on updating cart",
+			})
+		}
+		return
+	}
+
+	GetCart(c)
+}
+
+func parseProductIDParam(c *gin.Context) (uint, bool) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 64)
+	if err != nil || productID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "error on parsing product id",
+		})
+		return 0, false
+	}
+	return uint(productID), true
+}
+
+// SetCartItem godoc
+// @Summary      Задаёт количество товара в корзине
+// @Description  Выставляет точное количество товара в корзине, 0 удаляет позицию. Возвращает пересчитанную корзину
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
+// @Param product_id path uint true "ID товара"
+// @Param quantity body models.RequestSetCartItem true "Количество"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/cart/items/{product_id} [put]
+func SetCartItem(c *gin.Context) {
+	productID, ok := parseProductIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req models.RequestSetCartItem
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "quantity is required",
+		})
+		return
+	}
+
+	updateCartLines(c, []models.RequestCartLine{{ProductID: productID, VariantID: req.VariantID, Quantity: req.Quantity}})
+}
+
+// RemoveCartLine godoc
+// @Summary      Удаляет позицию корзины
+// @Description  Удаляет товар из корзины целиком, независимо от количества: все варианты или только variant_id. Возвращает пересчитанную корзину
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
+// @Param product_id path uint true "ID товара"
+// @Param variant_id query uint false "ID варианта"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/cart/items/{product_id} [delete]
+func RemoveCartLine(c *gin.Context) {
+	productID, ok := parseProductIDParam(c)
+	if !ok {
+		return
+	}
+
+	// Без variant_id удаляются все варианты товара
+	variantID, err := strconv.ParseUint(c.DefaultQuery("variant_id", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "error on parsing variant id",
+		})
+		return
+	}
+
+	var zero uint
+	updateCartLines(c, []models.RequestCartLine{{ProductID: productID, VariantID: uint(variantID), Quantity: &zero}})
+}
+
+// BulkUpdateCart godoc
+// @Summary      Меняет несколько позиций корзины
+// @Description  Выставляет количества сразу для нескольких товаров в одной транзакции: либо применяются все изменения, либо ни одного. 0 удаляет позицию
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
+// @Param items body models.RequestBulkCartItems true "Новые количества"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/cart/items [put]
+func BulkUpdateCart(c *gin.Context) {
+	var req models.RequestBulkCartItems
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "error on parsing cart items",
+		})
+		return
+	}
+
+	updateCartLines(c, req.Items)
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	errCategoryCycle = errors.New("category cannot be nested into itself")
+	errCategoryInUse = errors.New("category has products, subcategories or coupons")
+)
+
+// categorySubtree — id категории с данным slug и всех её подкатегорий
+const categorySubtree = `WITH RECURSIVE tree AS (
+	SELECT id FROM categories WHERE slug = ? AND deleted_at IS NULL
+	UNION
+	SELECT categories.id FROM categories JOIN tree ON categories.parent_id = tree.id WHERE categories.deleted_at IS NULL
+) SELECT id FROM tree`
+
+// resolveCategory находит категорию по slug для привязки к ней товара
+func resolveCategory(slug string) (models.Category, error) {
+	var category models.Category
+
+	if err := postgres.DB.Where("slug = ?", strings.TrimSpace(slug)).First(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return category, fmt.Errorf("%w: %s", errCategoryNotFound, slug)
+		}
+		return category, err
+	}
+
+	return category, nil
+}
+
+// respondCategoryError отвечает на ошибку resolveCategory
+func respondCategoryError(c *gin.Context, err error) {
+	if errors.Is(err, errCategoryNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("error on getting category: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "error on getting category",
+	})
+}
+
+// requestLanguage берёт язык из ?lang= или Accept-Language, по умолчанию русский
+func requestLanguage(c *gin.Context) string {
+	lang := c.Query("lang")
+	if lang == "" {
+		lang = c.GetHeader("Accept-Language")
+	}
+
+	// "en-US,en;q=0.9" -> "en"
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_,;"); i >= 0 {
+		lang = lang[:i]
+	}
+
+	if lang == "" {
+		return models.DefaultLanguage
+	}
+	return lang
+}
+
+// GetCategories godoc
+// @Summary      Возвращает дерево категорий
+// @Description  Возвращает категории деревом с названиями на запрошенном языке. product_count включает товары подкатегорий
+// @Tags         Categories
+// @Accept       json
+// @Produce      json
+// @Param lang query string false "Язык названий (ru, en), по умолчанию из Accept-Language"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/categories [get]
+func GetCategories(c *gin.Context) {
+	var categories []*models.Category
+
+	if err := postgres.DB.Order("sort_order ASC, id ASC").Find(&categories).Error; err != nil {
+		log.Printf("error on getting categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting categories",
+		})
+		return
+	}
+
+	var counts []struct {
+		CategoryID uint
+		Count int64
+	}
+	if err := postgres.DB.Model(&models.Product{}).Select("category_id, COUNT(*) AS count").Where("category_id IS NOT NULL").Group("category_id").Scan(&counts).Error; err != nil {
+		log.Printf("error on counting category products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting categories",
+		})
+		return
+	}
+
+	lang := requestLanguage(c)
+	byID := make(map[uint]*models.Category, len(categories))
+	for _, category := range categories {
+		category.Name = category.LocalizedName(lang)
+		category.Children = []*models.Category{}
+		byID[category.ID] = category
+	}
+
+	for _, count := range counts {
+		if category, ok := byID[count.CategoryID]; ok {
+			category.ProductCount = count.Count
+		}
+	}
+
+	// Категории уже отсортированы, поэтому дети добавляются в нужном порядке.
+	// Категория с удалённым родителем показывается на верхнем уровне.
+	roots := []*models.Category{}
+	for _, category := range categories {
+		if parent, ok := byID[derefUint(category.ParentID)]; ok {
+			parent.Children = append(parent.Children, category)
+		} else {
+			roots = append(roots, category)
+		}
+	}
+
+	for _, root := range roots {
+		sumProductCounts(root)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"categories": roots,
+	})
+}
+
+// sumProductCounts добавляет к числу товаров категории товары её подкатегорий
+func sumProductCounts(category *models.Category) int64 {
+	for _, child := range category.Children {
+		category.ProductCount += sumProductCounts(child)
+	}
+	return category.ProductCount
+}
+
+func derefUint(value *uint) uint {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+// CreateCategory godoc
+// @Summary      Создаёт категорию
+// @Description  Создаёт категорию товаров, при необходимости вложенную в parent_id
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param category body models.RequestCategory true "Категория"
+// @Success      201  {object}  models.Category
+// @Failure      400  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/categories [post]
+func CreateCategory(c *gin.Context) {
+	var category models.Category
+
+	if !bindCategory(c, &category) {
+		return
+	}
+
+	if err := postgres.DB.Create(&category).Error; err != nil {
+		log.Printf("error on creating category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on creating category",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+// UpdateCategory godoc
+// @Summary      Обновляет категорию
+// @Description  Меняет slug, названия, родителя и порядок категории. Slug у товаров категории обновляется вместе с ней
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID категории"
+// @Param category body models.RequestCategory true "Категория"
+// @Success      200  {object}  models.Category
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/categories/{id} [put]
+func UpdateCategory(c *gin.Context) {
+	var category models.Category
+
+	if err := postgres.DB.First(&category, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "category not found",
+		})
+		return
+	}
+
+	if !bindCategory(c, &category) {
+		return
+	}
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("slug", "names", "parent_id", "sort_order").Updates(&category).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Product{}).Where("category_id = ?", category.ID).Update("category", category.Slug).Error
+	})
+
+	if err != nil {
+		log.Printf("error on updating category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on updating category",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+// DeleteCategory godoc
+// @Summary      Удаляет категорию
+// @Description  Удаляет пустую категорию. Категорию с товарами, подкатегориями или купонами удалить нельзя
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID категории"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/categories/{id} [delete]
+func DeleteCategory(c *gin.Context) {
+	var category models.Category
+
+	if err := postgres.DB.First(&category, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "category not found",
+		})
+		return
+	}
+
+	// Товары считаем вместе с удалёнными: они по-прежнему ссылаются на категорию
+	var products, children, coupons int64
+	if err := postgres.DB.Unscoped().Model(&models.Product{}).Where("category_id = ?", category.ID).Count(&products).Error; err != nil {
+		log.Printf("error on counting category products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting category",
+		})
+		return
+	}
+	if err := postgres.DB.Model(&models.Category{}).Where("parent_id = ?", category.ID).Count(&children).Error; err != nil {
+		log.Printf("error on counting subcategories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting category",
+		})
+		return
+	}
+
+	if err := postgres.DB.Model(&models.Coupon{}).Where("category_id = ?", category.ID).Count(&coupons).Error; err != nil {
+		log.Printf("error on counting category coupons: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting category",
+		})
+		return
+	}
+
+	if products > 0 || children > 0 || coupons > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": errCategoryInUse.Error(),
+		})
+		return
+	}
+
+	if err := postgres.DB.Delete(&category).Error; err != nil {
+		log.Printf("error on deleting category: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting category",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "category deleted successfully",
+	})
+}
+
+// bindCategory разбирает категорию из запроса в category и проверяет slug
+// и родителя. При ошибке сам отвечает клиенту и возвращает false.
+func bindCategory(c *gin.Context, category *models.Category) bool {
+	var req models.RequestCategory
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("error on parsing category: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid category data",
+		})
+		return false
+	}
+
+	slug := strings.ToLower(strings.TrimSpace(req.Slug))
+	if slug == "" || strings.ContainsAny(slug, " \t/?#") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug must be a single word without spaces or slashes"})
+		return false
+	}
+
+	var duplicates int64
+	if err := postgres.DB.Model(&models.Category{}).Where("slug = ? AND id <> ?", slug, category.ID).Count(&duplicates).Error; err != nil {
+		log.Printf("error on checking category slug: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on checking category slug"})
+		return false
+	}
+	if duplicates > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "category slug already exists"})
+		return false
+	}
+
+	if req.ParentID != nil {
+		if err := checkCategoryParent(category.ID, *req.ParentID); err != nil {
+			if errors.Is(err, errCategoryNotFound) || errors.Is(err, errCategoryCycle) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return false
+			}
+
+			log.Printf("error on checking category parent: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error on checking category parent"})
+			return false
+		}
+	}
+
+	category.Slug = slug
+	category.Names = req.Names
+	category.ParentID = req.ParentID
+	category.SortOrder = req.SortOrder
+
+	return true
+}
+
+// checkCategoryParent проверяет, что родитель существует и не лежит внутри
+// самой категории: иначе дерево замкнётся в цикл
+func checkCategoryParent(categoryID uint, parentID uint) error {
+	for id := parentID; id != 0; {
+		if id == categoryID {
+			return errCategoryCycle
+		}
+
+		var parent models.Category
+		if err := postgres.DB.Select("id", "parent_id").First(&parent, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: parent %d", errCategoryNotFound, id)
+			}
+			return err
+		}
+
+		id = derefUint(parent.ParentID)
+	}
+
+	return nil
+}
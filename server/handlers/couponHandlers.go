@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"errors"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// couponRejectedError оборачивает причину, по которой купон нельзя применить
+type couponRejectedError struct {
+	Err error
+}
+
+func (e *couponRejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *couponRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// checkCouponUserLimit проверяет, не исчерпал ли пользователь свой лимит на купон
+func checkCouponUserLimit(tx *gorm.DB, coupon models.Coupon, userID uint) error {
+	if coupon.PerUserLimit == nil || userID == 0 {
+		return nil
+	}
+
+	var used int64
+	if err := tx.Model(&models.CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", coupon.ID, userID).Count(&used).Error; err != nil {
+		return err
+	}
+
+	if used >= int64(*coupon.PerUserLimit) {
+		return &couponRejectedError{Err: models.ErrCouponUserLimit}
+	}
+
+	return nil
+}
+
+// redeemCoupon засчитывает использование купона заказом. Общий лимит проверяется
+// условным UPDATE, поэтому параллельные заказы не превысят его.
+func redeemCoupon(tx *gorm.DB, coupon models.Coupon, order models.Order) error {
+	if err := checkCouponUserLimit(tx, coupon, order.UserID); err != nil {
+		return err
+	}
+
+	result := tx.Model(&models.Coupon{}).Where("id = ? AND (usage_limit IS NULL OR used_count < usage_limit)", coupon.ID).UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &couponRejectedError{Err: models.ErrCouponUsageLimit}
+	}
+
+	return tx.Create(&models.CouponRedemption{CouponID: coupon.ID, UserID: order.UserID, OrderID: order.ID}).Error
+}
+
+// releaseCoupon возвращает использование купона отменённым заказом
+func releaseCoupon(tx *gorm.DB, orderID uint) error {
+	var redemption models.CouponRedemption
+	if err := tx.Where("order_id = ?", orderID).First(&redemption).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := tx.Unscoped().Model(&models.Coupon{}).Where("id = ? AND used_count > 0", redemption.CouponID).UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Delete(&redemption).Error
+}
+
+// ApplyCoupon godoc
+// @Summary      Применяет купон к корзине
+// @Description  Проверяет купон и привязывает его к корзине. Скидка пересчитывается при каждом чтении корзины и фиксируется в заказе при оформлении
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param coupon body models.RequestApplyCoupon true "Код купона"
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
+// @Success      200  {object}  models.Cart
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/cart/apply_coupon [post]
+func ApplyCoupon(c *gin.Context) {
+	var req models.RequestApplyCoupon
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "coupon code is required",
+		})
+		return
+	}
+
+	var coupon models.Coupon
+	if err := postgres.DB.Where("code = ?", models.NormalizeCouponCode(req.Code)).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "coupon not found",
+			})
+			return
+		}
+
+		log.Printf("error on getting coupon: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting coupon",
+		})
+		return
+	}
+
+	var cart models.Cart
+	if err := preloadCartItems(postgres.DB).FirstOrCreate(&cart, cartOwner(c)).Error; err != nil {
+		log.Printf("error on getting cart: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting user's cart",
+		})
+		return
+	}
+
+	if err := coupon.Usable(time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := checkCouponUserLimit(postgres.DB, coupon, c.GetUint("userID")); err != nil {
+		var rejectedErr *couponRejectedError
+		if errors.As(err, &rejectedErr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": rejectedErr.Error(),
+			})
+			return
+		}
+
+		log.Printf("error on checking coupon usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on applying coupon",
+		})
+		return
+	}
+
+	// Пробный расчёт покажет, подходит ли корзина под условия купона
+	cart.Coupon = &coupon
+	cart.Revalidate()
+	cart.CalculateTotals(taxRate(), shippingCost())
+
+	if cart.CouponCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": cart.Warnings[len(cart.Warnings)-1].Message,
+		})
+		return
+	}
+
+	if err := postgres.DB.Model(&cart).UpdateColumn("coupon_id", coupon.ID).Error; err != nil {
+		log.Printf("error on applying coupon: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on applying coupon",
+		})
+		return
+	}
+
+	GetCart(c)
+}
+
+// RemoveCoupon godoc
+// @Summary      Убирает купон из корзины
+// @Description  Отвязывает купон от корзины
+// @Tags         Cart
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string false "Токен в формате Bearer {token}; без него используется гостевая корзина" default(Bearer )
+// @Param X-Cart-Token header string false "Токен гостевой корзины, если не передан в cookie cart_token"
+// @Success      200  {object}  models.Cart
+// @Failure      500  {object}  map[string]string
+// @Router       /api/cart/apply_coupon [delete]
+func RemoveCoupon(c *gin.Context) {
+	if err := postgres.DB.Model(&models.Cart{}).Where(cartOwner(c)).UpdateColumn("coupon_id", nil).Error; err != nil {
+		log.Printf("error on removing coupon: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on removing coupon",
+		})
+		return
+	}
+
+	GetCart(c)
+}
+
+// GetCoupons godoc
+// @Summary      Возвращает купоны
+// @Description  Возвращает все купоны вместе с числом использований
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/coupons [get]
+func GetCoupons(c *gin.Context) {
+	var coupons []models.Coupon
+
+	if err := postgres.DB.Order("id DESC").Find(&coupons).Error; err != nil {
+		log.Printf("error on getting coupons: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting coupons",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"coupons": coupons,
+	})
+}
+
+// CreateCoupon godoc
+// @Summary      Создаёт купон
+// @Description  Создаёт купон с процентной или фиксированной скидкой либо бесплатной доставкой
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param coupon body models.RequestCoupon true "Условия купона"
+// @Success      201  {object}  models.Coupon
+// @Failure      400  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/coupons [post]
+func CreateCoupon(c *gin.Context) {
+	var coupon models.Coupon
+
+	if !bindCoupon(c, &coupon) {
+		return
+	}
+
+	if err := postgres.DB.Create(&coupon).Error; err != nil {
+		log.Printf("error on creating coupon: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on creating coupon",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, coupon)
+}
+
+// UpdateCoupon godoc
+// @Summary      Обновляет купон
+// @Description  Полностью заменяет условия купона. Счётчик использований сохраняется
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID купона"
+// @Param coupon body models.RequestCoupon true "Условия купона"
+// @Success      200  {object}  models.Coupon
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/coupons/{id} [put]
+func UpdateCoupon(c *gin.Context) {
+	var coupon models.Coupon
+
+	if err := postgres.DB.First(&coupon, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "coupon not found",
+		})
+		return
+	}
+
+	if !bindCoupon(c, &coupon) {
+		return
+	}
+
+	if err := postgres.DB.Select("*").Omit("created_at", "used_count").Save(&coupon).Error; err != nil {
+		log.Printf("error on updating coupon: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on updating coupon",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// DeleteCoupon godoc
+// @Summary      Удаляет купон
+// @Description  Мягко удаляет купон: он перестаёт действовать в корзинах, история заказов не меняется
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID купона"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/coupons/{id} [delete]
+func DeleteCoupon(c *gin.Context) {
+	result := postgres.DB.Delete(&models.Coupon{}, c.Param("id"))
+
+	if result.Error != nil {
+		log.Printf("error on deleting coupon: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting coupon",
+		})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "coupon not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "coupon deleted successfully",
+	})
+}
+
+// bindCoupon разбирает условия купона из запроса в coupon и проверяет их.
+// При ошибке сам отвечает клиенту и возвращает false.
+func bindCoupon(c *gin.Context, coupon *models.Coupon) bool {
+	var req models.RequestCoupon
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("error on parsing coupon: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid coupon data",
+		})
+		return false
+	}
+
+	code := models.NormalizeCouponCode(req.Code)
+
+	switch {
+	case code == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "coupon code is required"})
+		return false
+	case req.Type == models.CouponTypePercentage && (req.Value <= 0 || req.Value > 100):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "percentage must be between 0 and 100"})
+		return false
+	case req.Type == models.CouponTypeFixed && req.Value <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fixed discount must be positive"})
+		return false
+	case req.StartsAt != nil && req.ExpiresAt != nil && !req.ExpiresAt.After(*req.StartsAt):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be after starts_at"})
+		return false
+	}
+
+	var duplicates int64
+	if err := postgres.DB.Model(&models.Coupon{}).Where("code = ? AND id <> ?", code, coupon.ID).Count(&duplicates).Error; err != nil {
+		log.Printf("error on checking coupon code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on checking coupon code"})
+		return false
+	}
+	if duplicates > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "coupon code already exists"})
+		return false
+	}
+
+	coupon.CategoryID = nil
+	if strings.TrimSpace(req.Category) != "" {
+		category, err := resolveCategory(req.Category)
+		if err != nil {
+			respondCategoryError(c, err)
+			return false
+		}
+		coupon.CategoryID = &category.ID
+	}
+
+	coupon.Code = code
+	coupon.Type = req.Type
+	coupon.Value = req.Value
+	coupon.MinCartValue = req.MinCartValue
+	coupon.ProductID = req.ProductID
+	coupon.FreeShipping = req.FreeShipping
+	coupon.StartsAt = req.StartsAt
+	coupon.ExpiresAt = req.ExpiresAt
+	coupon.UsageLimit = req.UsageLimit
+	coupon.PerUserLimit = req.PerUserLimit
+	coupon.Active = req.Active == nil || *req.Active
+
+	if coupon.Type == models.CouponTypeFreeShipping {
+		coupon.Value = 0
+	}
+
+	return true
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	guestCartCookie = "cart_token"
+	guestCartHeader = "X-Cart-Token"
+	guestCartMaxAge = 30 * 24 * 60 * 60
+)
+
+// OptionalAuthMiddleware проверяет токен, только если он передан, и
+// пропускает анонимные запросы без userID
+func OptionalAuthMiddleware(c *gin.Context) {
+	if c.GetHeader("Authorization") != "" {
+		AuthMiddleware(c)
+		return
+	}
+	c.Next()
+}
+
+// CartSessionMiddleware пускает к корзине и пользователей, и гостей.
+// С заголовком Authorization работает как AuthMiddleware, без него — выдаёт
+// или принимает непрозрачный токен гостевой корзины из cookie или X-Cart-Token.
+func CartSessionMiddleware(c *gin.Context) {
+	if c.GetHeader("Authorization") != "" {
+		AuthMiddleware(c)
+		return
+	}
+
+	token, ok := guestCartToken(c)
+	if !ok {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			log.Printf("error on creating guest cart token: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error on creating guest cart"})
+			return
+		}
+		token = base64.RawURLEncoding.EncodeToString(buf)
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(guestCartCookie, token, guestCartMaxAge, "/", "", false, true)
+	c.Header(guestCartHeader, token)
+
+	c.Set("cartToken", token)
+	c.Next()
+}
+
+// guestCartToken достаёт токен гостевой корзины из заголовка или cookie
+func guestCartToken(c *gin.Context) (string, bool) {
+	token := strings.TrimSpace(c.GetHeader(guestCartHeader))
+	if token == "" {
+		token, _ = c.Cookie(guestCartCookie)
+	}
+
+	// Токен — 32 случайных байта в base64url
+	if len(token) != 43 {
+		return "", false
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(token); err != nil {
+		return "", false
+	}
+
+	return token, true
+}
+
+// cartOwner возвращает условие выборки корзины текущего пользователя или гостя.
+// Подходит и для Where, и для FirstOrCreate.
+func cartOwner(c *gin.Context) models.Cart {
+	if userID := c.GetUint("userID"); userID != 0 {
+		return models.Cart{UserID: userID}
+	}
+
+	token := c.GetString("cartToken")
+	return models.Cart{GuestToken: &token}
+}
+
+// mergeGuestCart переносит гостевую корзину в корзину пользователя после входа.
+// Количества одинаковых вариантов складываются, но не больше остатка на складе;
+// удалённые товары пропускаются. Гостевая корзина после этого удаляется.
+func mergeGuestCart(c *gin.Context, userID uint) {
+	token, ok := guestCartToken(c)
+	if !ok {
+		return
+	}
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var guestCart models.Cart
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").Where("guest_token = ?", token).First(&guestCart).Error; err != nil {
+			return err
+		}
+
+		var userCart models.Cart
+		if err := tx.FirstOrCreate(&userCart, models.Cart{UserID: userID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&userCart, userCart.ID).Error; err != nil {
+			return err
+		}
+
+		quantities := map[uint]uint{}
+		for _, item := range userCart.Items {
+			quantities[item.VariantID] = item.Quantity
+		}
+
+		for _, item := range guestCart.Items {
+			variant, err := resolveVariant(tx, item.ProductID, item.VariantID)
+			if err != nil {
+				if errors.Is(err, errProductNotFound) || errors.Is(err, errVariantNotFound) {
+					continue
+				}
+				return err
+			}
+
+			// Товара не осталось или у пользователя его уже не меньше, чем на складе:
+			// позицию пропускаем, а не уменьшаем или удаляем
+			quantity := min(quantities[variant.ID]+item.Quantity, variant.Stock)
+			if quantity <= quantities[variant.ID] {
+				continue
+			}
+			if err := setCartItemQuantity(tx, userCart.ID, variant.ProductID, variant.ID, quantity); err != nil {
+				return err
+			}
+		}
+
+		// Купон гостя переносится, только если у пользователя своего нет
+		if userCart.CouponID == nil && guestCart.CouponID != nil {
+			if err := tx.Model(&userCart).UpdateColumn("coupon_id", *guestCart.CouponID).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(&guestCart).Error
+	})
+
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		// Вход важнее корзины: ошибку слияния только логируем
+		log.Printf("error on merging guest cart into user %d cart: %v", userID, err)
+		return
+	}
+
+	c.SetCookie(guestCartCookie, "", -1, "/", "", false, true)
+}
@@ -1,42 +1,620 @@
 package handlers
 
 import (
-	"os"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"kotoshop/storage"
+	"kotoshop/thumbnails"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+// maxImageSize — максимальный размер загружаемой картинки
+const maxImageSize = 10 << 20
+
+// Ключи закрытых картинок начинаются с этого префикса: так при отдаче
+// видно, что нужна подпись, без запроса в базу
+const privateImagePrefix = "private-"
+
+const (
+	// Срок жизни подписанной ссылки по умолчанию
+	signedImageURLTTL = 15 * time.Minute
+	// Дольше подписанная ссылка не живёт
+	maxSignedImageURLTTL = 7 * 24 * time.Hour
+)
+
+// Содержимое открытой картинки по её ключу не меняется, поэтому
+// её можно кэшировать навсегда, в том числе в CDN
+const publicImageCacheControl = "public, max-age=31536000, immutable"
+
+// Картинки отдаются без авторизации, поэтому одновременно строится не больше
+// одной миниатюры на ядро: остальные ждут в очереди, а готовые миниатюры
+// отдаются из кэша без ожидания. Число разных миниатюр ограничивает thumbnails.Sizes.
+var thumbnailRenders = make(chan struct{}, runtime.NumCPU())
+
+// Тип картинки определяется по содержимому файла, а не по расширению
+// или заголовку Content-Type от клиента
+var allowedImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+var (
+	errImagesMismatch        = errors.New("image_ids must list every product image exactly once")
+	errInvalidImageSignature = errors.New("image link is invalid or expired")
+)
+
+// GetProductImage godoc
+// @Summary      Возвращает картинку
+// @Description  Отдаёт загруженную картинку товара по ключу из поля url, авторизация не нужна. Закрытые картинки отдаются только по подписанной ссылке с expires и signature. С width и/или height отдаёт уменьшенную копию (без увеличения), с format — перекодированную. Размеры — только из списка 64, 128, 256, 340, 512, 800, 1024, 1600. Миниатюры строятся при первом запросе и кэшируются на диске. Поддерживаются If-None-Match, If-Modified-Since (304), Range и HEAD
+// @Tags         Images
+// @Produce      image/jpeg,image/png,image/gif,image/webp
+// @Param filename path string true "Ключ картинки"
+// @Param width query int false "Ширина миниатюры" Enums(64, 128, 256, 340, 512, 800, 1024, 1600)
+// @Param height query int false "Высота миниатюры" Enums(64, 128, 256, 340, 512, 800, 1024, 1600)
+// @Param fit query string false "Как вписать в width×height: contain (по умолчанию), cover, fill"
+// @Param format query string false "Формат: jpeg, png или webp. По умолчанию jpeg для jpeg, webp для webp, png для остальных"
+// @Param expires query int false "Срок действия подписанной ссылки, unix-время"
+// @Param signature query string false "Подпись ссылки"
+// @Success      200  {file}  binary
+// @Success      206  {file}  binary
+// @Success      304  "Картинка не изменилась"
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      415  {object}  map[string]string
+// @Failure      416  "Диапазон за пределами файла"
+// @Failure      500  {object}  map[string]string
+// @Router       /api/image/get/{filename} [get]
 func GetProductImage(c *gin.Context) {
-	// 1. Получаем имя файла из параметров URL
-	filename := c.Param("filename")
-	if filename == "" {
-			c.JSON(400, gin.H{"error": "filename is required"})
+	// Ключи картинок плоские, поэтому любые пути отбрасываем
+	key := filepath.Base(c.Param("filename"))
+
+	cacheControl := publicImageCacheControl
+	if strings.HasPrefix(key, privateImagePrefix) {
+		expires, err := verifyImageSignature(key, c.Query("expires"), c.Query("signature"), time.Now())
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Закрытую картинку не кладём в общие кэши и не храним дольше, чем живёт ссылка
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(time.Until(expires).Seconds()))
+	}
+
+	options, err := thumbnails.ParseOptions(c.Query("width"), c.Query("height"), c.Query("fit"), c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if options.IsOriginal() {
+		serveOriginalImage(c, key, cacheControl)
+		return
+	}
+
+	serveThumbnail(c, key, options.WithSourceFormat(mime.TypeByExtension(filepath.Ext(key))), cacheControl)
+}
+
+func serveOriginalImage(c *gin.Context, key string, cacheControl string) {
+	// Ключ меняется вместе с содержимым, поэтому он же служит ETag
+	etag := `"` + key + `"`
+	if notModified(c, etag, cacheControl) {
+		return
+	}
+
+	blob, err := storage.Blobs.Get(c.Request.Context(), key)
+	if err != nil {
+		respondImageError(c, key, err)
+		return
+	}
+	defer blob.Close()
+
+	contentType := blob.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(key))
+	}
+
+	content, err := seekableBlob(blob)
+	if err != nil {
+		respondImageError(c, key, err)
+		return
+	}
+
+	serveImage(c, etag, cacheControl, contentType, blob.ModTime, content)
+}
+
+func serveThumbnail(c *gin.Context, key string, options thumbnails.Options, cacheControl string) {
+	etag := `"` + options.Name(key) + `"`
+	if notModified(c, etag, cacheControl) {
+		return
+	}
+
+	// Миниатюру может ждать несколько запросов, поэтому обрыв первого
+	// из них не должен прерывать её построение
+	ctx := context.WithoutCancel(c.Request.Context())
+
+	file, err := thumbnails.Cache.Open(key, options, func() ([]byte, error) {
+		thumbnailRenders <- struct{}{}
+		defer func() { <-thumbnailRenders }()
+
+		blob, err := storage.Blobs.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		defer blob.Close()
+
+		return thumbnails.Render(blob, options)
+	})
+	if err != nil {
+		respondImageError(c, key, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		respondImageError(c, key, err)
+		return
+	}
+
+	serveImage(c, etag, cacheControl, options.ContentType(), info.ModTime(), file)
+}
+
+// notModified отвечает 304, если у клиента уже есть эта версия картинки.
+// ETag известен заранее, поэтому хранилище в этом случае не трогаем.
+func notModified(c *gin.Context, etag string, cacheControl string) bool {
+	for _, candidate := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		// Для картинок слабое сравнение ETag не отличается от сильного
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") != etag {
+			continue
+		}
+
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", cacheControl)
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// serveImage отдаёт картинку через http.ServeContent: он отвечает на HEAD,
+// Range и If-Range и сам проверяет If-None-Match и If-Modified-Since
+func serveImage(c *gin.Context, etag string, cacheControl string, contentType string, modTime time.Time, content io.ReadSeeker) {
+	header := c.Writer.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", cacheControl)
+	header.Set("Content-Type", contentType)
+
+	http.ServeContent(c.Writer, c.Request, "", modTime, content)
+}
+
+// seekableBlob нужен для Range-запросов. Файл с диска отдаётся как есть,
+// а поток из S3 читается в память: картинки не больше maxImageSize.
+func seekableBlob(blob *storage.Blob) (io.ReadSeeker, error) {
+	if content, ok := blob.ReadCloser.(io.ReadSeeker); ok {
+		return content, nil
+	}
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func respondImageError(c *gin.Context, key string, err error) {
+	switch {
+	case errors.Is(err, storage.ErrBlobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+	case errors.Is(err, thumbnails.ErrUnsupportedImage):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	default:
+		log.Printf("error on getting image %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on getting image"})
+	}
+}
+
+// UploadProductImage godoc
+// @Summary      Загружает картинку товара
+// @Description  Добавляет картинку в конец галереи товара. Принимаются jpeg, png, gif и webp до 10 МБ, тип проверяется по содержимому. Первая открытая картинка становится обложкой товара, если её ещё нет. Закрытая картинка (private=true) не показывается в каталоге, в ответе для неё приходит подписанная ссылка
+// @Tags         Images
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param image formData file true "Картинка"
+// @Param private formData bool false "Закрытая картинка, доступна только по подписанной ссылке"
+// @Success      201  {object}  models.ProductImage
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      413  {object}  map[string]string
+// @Failure      415  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id}/images [post]
+func UploadProductImage(c *gin.Context) {
+	var product models.Product
+	if !loadProduct(c, &product) {
+		return
+	}
+
+	// Запас на заголовки multipart сверх размера самого файла
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageSize+1<<20)
+
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image is too large"})
+			return
+		}
+
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image file is required"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image file is empty"})
+		return
+	}
+	if header.Size > maxImageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image is too large"})
+		return
+	}
+
+	mtype, err := mimetype.DetectReader(file)
+	if err != nil {
+		log.Printf("error on detecting image type: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "error on reading image"})
+		return
+	}
+	if !mimetype.EqualsAny(mtype.String(), allowedImageTypes...) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "unsupported image type: " + mtype.String(),
+		})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		log.Printf("error on rewinding image: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on saving image"})
+		return
+	}
+
+	private := c.PostForm("private") == "true"
+
+	key, err := newImageKey(mtype.Extension())
+	if err != nil {
+		log.Printf("error on creating image key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on saving image"})
+		return
+	}
+	if private {
+		key = privateImagePrefix + key
+	}
+
+	if err := storage.Blobs.Put(c.Request.Context(), key, file, header.Size, mtype.String()); err != nil {
+		log.Printf("error on storing image %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on saving image"})
+		return
+	}
+
+	image := models.ProductImage{
+		ProductID: product.ID,
+		Key: key,
+		ContentType: mtype.String(),
+		Size: header.Size,
+		Private: private,
+	}
+
+	err = postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Select("COALESCE(MAX(position) + 1, 0)").Scan(&image.Position).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&image).Error; err != nil {
+			return err
+		}
+
+		// Закрытая картинка не может быть обложкой: обложку видят все
+		if product.Image != "" || image.Private {
+			return nil
+		}
+		return tx.Model(&product).Update("image", models.ImagePath+key).Error
+	})
+
+	if err != nil {
+		// Файл без записи в базе никому не виден, удаляем его
+		if deleteErr := storage.Blobs.Delete(c.Request.Context(), key); deleteErr != nil {
+			log.Printf("error on deleting orphaned image %s: %v", key, deleteErr)
+		}
+
+		log.Printf("error on saving image: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on saving image"})
+		return
+	}
+
+	image.URL = models.ImagePath + key
+	if image.Private {
+		image.URL = signedImageURL(key, time.Now().Add(signedImageURLTTL).Truncate(time.Second))
+	}
+	c.JSON(http.StatusCreated, image)
+}
+
+// DeleteProductImage godoc
+// @Summary      Удаляет картинку товара
+// @Description  Убирает картинку из галереи и из хранилища. Если она была обложкой, обложкой становится следующая картинка галереи
+// @Tags         Images
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param image_id path uint true "ID картинки"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id}/images/{image_id} [delete]
+func DeleteProductImage(c *gin.Context) {
+	var product models.Product
+	if !loadProduct(c, &product) {
+		return
+	}
+
+	var image models.ProductImage
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND product_id = ?", c.Param("image_id"), product.ID).First(&image).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&image).Error; err != nil {
+			return err
+		}
+
+		if product.Image != image.URL {
+			return nil
+		}
+
+		cover := ""
+		var next models.ProductImage
+		err := tx.Where("product_id = ? AND NOT private", product.ID).Order("position ASC, id ASC").First(&next).Error
+		if err == nil {
+			cover = next.URL
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		return tx.Model(&product).Update("image", cover).Error
+	})
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
 			return
+		}
+
+		log.Printf("error on deleting image: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on deleting image"})
+		return
+	}
+
+	// Запись уже удалена, поэтому потерянные файлы только логируем
+	if err := storage.Blobs.Delete(c.Request.Context(), image.Key); err != nil {
+		log.Printf("error on deleting image %s from storage: %v", image.Key, err)
+	}
+	if err := thumbnails.Cache.Purge(image.Key); err != nil {
+		log.Printf("error on deleting thumbnails of image %s: %v", image.Key, err)
 	}
 
-	// 2. Безопасное формирование пути к файлу
-	safePath := filepath.Join("productImages", filepath.Base(filename))
-	
-	// 3. Проверка существования файла
-	if _, err := os.Stat(safePath); os.IsNotExist(err) {
-			c.JSON(404, gin.H{"error": "image not found"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "image deleted successfully",
+	})
+}
+
+// ReorderProductImages godoc
+// @Summary      Меняет порядок картинок товара
+// @Description  Задаёт новый порядок галереи. В image_ids должны быть перечислены все картинки товара
+// @Tags         Images
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param order body models.RequestReorderImages true "Картинки в новом порядке"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id}/images/order [put]
+func ReorderProductImages(c *gin.Context) {
+	var product models.Product
+	if !loadProduct(c, &product) {
+		return
+	}
+
+	var req models.RequestReorderImages
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids are required"})
+		return
+	}
+
+	images := []models.ProductImage{}
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var existing []uint
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+
+		positions := make(map[uint]int, len(req.ImageIDs))
+		for position, id := range req.ImageIDs {
+			positions[id] = position
+		}
+		if len(positions) != len(req.ImageIDs) || len(positions) != len(existing) {
+			return errImagesMismatch
+		}
+
+		for _, id := range existing {
+			position, ok := positions[id]
+			if !ok {
+				return errImagesMismatch
+			}
+			if err := tx.Model(&models.ProductImage{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("product_id = ?", product.ID).Order("position ASC").Find(&images).Error
+	})
+
+	if err != nil {
+		if errors.Is(err, errImagesMismatch) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Printf("error on reordering images: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on reordering images"})
+		return
+	}
+
+	signPrivateImages(images)
+	c.JSON(http.StatusOK, gin.H{
+		"images": images,
+	})
+}
+
+// GetSignedImageURL godoc
+// @Summary      Выдаёт подписанную ссылку на картинку
+// @Description  Возвращает ссылку на картинку товара, которая действует ttl секунд (по умолчанию 15 минут, не больше 7 дней). Без такой ссылки закрытые картинки не отдаются. К ссылке можно добавить параметры миниатюры
+// @Tags         Images
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param image_id path uint true "ID картинки"
+// @Param ttl query int false "Срок действия ссылки в секундах"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id}/images/{image_id}/signed_url [get]
+func GetSignedImageURL(c *gin.Context) {
+	var product models.Product
+	if !loadProduct(c, &product) {
+		return
+	}
+
+	ttl := signedImageURLTTL
+	if raw := c.Query("ttl"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxSignedImageURLTTL {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("ttl must be between 1 and %d seconds", int(maxSignedImageURLTTL.Seconds())),
+			})
 			return
+		}
+		ttl = time.Duration(seconds) * time.Second
 	}
 
-	// 4. Определение MIME-типа
-	contentType := "image/jpeg"
-	switch filepath.Ext(filename) {
-	case ".png":
-			contentType = "image/png"
-	case ".gif":
-			contentType = "image/gif"
-	case ".webp":
-			contentType = "image/webp"
+	var image models.ProductImage
+	if err := postgres.DB.Where("id = ? AND product_id = ?", c.Param("image_id"), product.ID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+			return
+		}
+
+		log.Printf("error on getting image: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on getting image"})
+		return
 	}
 
-	// 5. Отправка файла с кэшированием
-	c.Header("Cache-Control", "public, max-age=31536000")
-	c.Header("Content-Type", contentType)
-	c.File(safePath)
-}
\ No newline at end of file
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	c.JSON(http.StatusOK, gin.H{
+		"url": signedImageURL(image.Key, expires),
+		"expires_at": expires,
+	})
+}
+
+// newImageKey возвращает случайный ключ файла с расширением ext
+func newImageKey(ext string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf) + ext, nil
+}
+
+// imageURLSecret — ключ подписи ссылок на закрытые картинки
+var imageURLSecret []byte
+
+// SetImageURLSecret задаёт ключ подписи ссылок на картинки. Он отдельный от
+// SECRET_KEY, которым подписываются токены: подписанные ссылки попадают в логи
+// и кэши, и ключ от них не должен давать подделать токен. Без ключа сервер
+// не запускается.
+func SetImageURLSecret(secret string) {
+	if secret == "" {
+		log.Fatalf("IMAGE_URL_SECRET is not set")
+	}
+	imageURLSecret = []byte(secret)
+}
+
+func imageSignature(key string, expires int64) []byte {
+	mac := hmac.New(sha256.New, imageURLSecret)
+	fmt.Fprintf(mac, "%s\n%d", key, expires)
+	return mac.Sum(nil)
+}
+
+// signedImageURL возвращает ссылку на картинку key, действующую до expires
+func signedImageURL(key string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", hex.EncodeToString(imageSignature(key, expires.Unix())))
+
+	return models.ImagePath + key + "?" + query.Encode()
+}
+
+// verifyImageSignature проверяет подпись ссылки и возвращает, до какого
+// момента она действует
+func verifyImageSignature(key string, expires string, signature string, now time.Time) (time.Time, error) {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return time.Time{}, errInvalidImageSignature
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, imageSignature(key, unix)) {
+		return time.Time{}, errInvalidImageSignature
+	}
+
+	expiresAt := time.Unix(unix, 0)
+	if !now.Before(expiresAt) {
+		return time.Time{}, errInvalidImageSignature
+	}
+	return expiresAt, nil
+}
+
+// signPrivateImages заменяет адреса закрытых картинок подписанными ссылками
+func signPrivateImages(images []models.ProductImage) {
+	expires := time.Now().Add(signedImageURLTTL).Truncate(time.Second)
+	for i := range images {
+		if images[i].Private {
+			images[i].URL = signedImageURL(images[i].Key, expires)
+		}
+	}
+}
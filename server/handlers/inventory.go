@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"kotoshop/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type stockShortage struct {
+	ProductID uint `json:"product_id"`
+	VariantID uint `json:"variant_id"`
+	SKU string `json:"sku"`
+	Title string `json:"title"`
+	Requested uint `json:"requested"`
+	Available uint `json:"available"`
+}
+
+// outOfStockError перечисляет позиции, которых не хватает на складе
+type outOfStockError struct {
+	Items []stockShortage
+}
+
+func (e *outOfStockError) Error() string {
+	titles := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		titles = append(titles, fmt.Sprintf("%s %s (запрошено %d, в наличии %d)", item.Title, item.SKU, item.Requested, item.Available))
+	}
+	return "недостаточно товара на складе: " + strings.Join(titles, ", ")
+}
+
+// reserveStock списывает остатки вариантов под позиции корзины. Строки товаров
+// и вариантов блокируются до конца транзакции, поэтому параллельные заказы не продадут
+// один и тот же остаток. Если чего-то не хватает, ничего не списывается
+// и возвращается *outOfStockError.
+func reserveStock(tx *gorm.DB, items []models.CartItem) error {
+	requested := make(map[uint]uint, len(items))
+	variantIDs := make([]uint, 0, len(items))
+	productIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		if _, ok := requested[item.VariantID]; !ok {
+			variantIDs = append(variantIDs, item.VariantID)
+			productIDs = append(productIDs, item.ProductID)
+		}
+		requested[item.VariantID] += item.Quantity
+	}
+
+	// Блокируем товары, затем варианты, каждый раз в порядке id, чтобы
+	// параллельные транзакции не взаимоблокировались
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", productIDs).Order("id").Find(&[]models.Product{}).Error; err != nil {
+		return err
+	}
+
+	var variants []models.ProductVariant
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", variantIDs).Order("id").Find(&variants).Error; err != nil {
+		return err
+	}
+
+	titles := make(map[uint]string, len(items))
+	for _, item := range items {
+		titles[item.VariantID] = item.Product.Title
+	}
+
+	shortage := &outOfStockError{}
+
+	// Удалённый вариант не найдётся: его нельзя купить, как и закончившийся
+	if len(variants) != len(variantIDs) {
+		found := make(map[uint]bool, len(variants))
+		for _, variant := range variants {
+			found[variant.ID] = true
+		}
+		for i, variantID := range variantIDs {
+			if !found[variantID] {
+				shortage.Items = append(shortage.Items, stockShortage{
+					ProductID: productIDs[i],
+					VariantID: variantID,
+					Title: titles[variantID],
+					Requested: requested[variantID],
+					Available: 0,
+				})
+			}
+		}
+	}
+
+	for _, variant := range variants {
+		if variant.Stock < requested[variant.ID] {
+			shortage.Items = append(shortage.Items, stockShortage{
+				ProductID: variant.ProductID,
+				VariantID: variant.ID,
+				SKU: variant.SKU,
+				Title: titles[variant.ID],
+				Requested: requested[variant.ID],
+				Available: variant.Stock,
+			})
+		}
+	}
+
+	if len(shortage.Items) > 0 {
+		return shortage
+	}
+
+	for _, variant := range variants {
+		if err := tx.Model(&variant).UpdateColumn("stock", gorm.Expr("stock - ?", requested[variant.ID])).Error; err != nil {
+			return err
+		}
+	}
+
+	return syncProductsFromVariants(tx, productIDs)
+}
+
+// releaseStock возвращает на склад варианты отменённых позиций заказа.
+// Позиции удалённых вариантов пропускаются.
+func releaseStock(tx *gorm.DB, items []models.OrderItem) error {
+	var productIDs []uint
+
+	for _, item := range items {
+		if item.VariantID == 0 {
+			continue
+		}
+
+		if err := tx.Unscoped().Model(&models.ProductVariant{}).Where("id = ?", item.VariantID).UpdateColumn("stock", gorm.Expr("stock + ?", item.Quantity)).Error; err != nil {
+			return err
+		}
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	return syncProductsFromVariants(tx, productIDs)
+}
+
+// syncProductsFromVariants пересчитывает цену (минимальную) и остаток (суммарный)
+// товаров по их неудалённым вариантам
+func syncProductsFromVariants(tx *gorm.DB, productIDs []uint) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	return tx.Exec(`UPDATE products SET
+		price = COALESCE((SELECT MIN(price) FROM product_variants WHERE product_id = products.id AND deleted_at IS NULL), price),
+		stock = COALESCE((SELECT SUM(stock) FROM product_variants WHERE product_id = products.id AND deleted_at IS NULL), 0)
+	WHERE id IN ?`, productIDs).Error
+}
+
+func respondNotEnoughStock(c *gin.Context, variant models.ProductVariant) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error": "not enough stock",
+		"product_id": variant.ProductID,
+		"variant_id": variant.ID,
+		"available": variant.Stock,
+	})
+}
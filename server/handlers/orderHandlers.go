@@ -1,106 +1,281 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"kotoshop/models"
 	"kotoshop/postgres"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CreateOrderRequest struct {
-	Address string `json:"address"`
+	// ID адреса из адресной книги пользователя
+	AddressID uint `json:"address_id" binding:"required" example:"1"`
+	// Сумма корзины, которую видел пользователь. Обязательна, если цены изменились
+	ExpectedTotal *float64 `json:"expected_total" example:"3000"`
 }
 
+var errCartEmpty = errors.New("cart is empty")
 
+// cartChangedError возвращается, когда пользователь не подтвердил новые суммы корзины
+type cartChangedError struct {
+	Warnings []models.CartWarning
+	Totals models.CartTotals
+}
+
+func (e *cartChangedError) Error() string {
+	return "cart has changed, confirm the new total"
+}
+
+// unavailableProductsError перечисляет удалённые товары, оставшиеся в корзине
+type unavailableProductsError struct {
+	ProductIDs []uint
+}
+
+func (e *unavailableProductsError) Error() string {
+	return "some products are no longer available"
+}
 
 // CreateOrder godoc
 // @Summary      Создает заказ
-// @Description  Создает заказ пользователя из продуктов его корзины
+// @Description  Создает заказ пользователя из продуктов его корзины по текущим ценам. Адрес берётся из адресной книги по address_id и копируется в заказ. Если цены изменились с момента добавления в корзину или expected_total не совпадает с суммой корзины, возвращает 409 с предупреждениями и новыми суммами. Повторный запрос с тем же Idempotency-Key возвращает уже созданный заказ
 // @Tags         Order
 // @Accept       json
 // @Produce      json
-// @Param address body req true "Данные заказа"
+// @Param order body CreateOrderRequest true "Адрес доставки и подтверждённая сумма"
 // @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param Idempotency-Key header string false "Ключ идемпотентности запроса"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]interface{}
 // @Failure      500  {object}  map[string]string
 // @Router       /api/order/create [post]
 func CreateOrder(c *gin.Context) {
 	userID := c.GetUint("userID")
+	idempotencyKey := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+
+	if len(idempotencyKey) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":"Idempotency-Key is too long",
+		})
+		return
+	}
+
+	if idempotencyKey != "" && respondExistingOrder(c, userID, idempotencyKey) {
+		return
+	}
 
 	var req CreateOrderRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("error on parsing address: %v", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error":"error on parsing order address",
+			"error":"address_id is required",
 		})
 		return 
 	}
 
-	var cart models.Cart 
+	var address models.Address
+	if err := postgres.DB.Where("id = ? AND user_id = ?", req.AddressID, userID).First(&address).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":"address not found",
+			})
+			return
+		}
 
-	if err := postgres.DB.Where("user_id = ?", userID).Preload("Items").First(&cart).Error; err != nil {
-		log.Printf("error on getting user cart: %v", err.Error())
+		log.Printf("error on getting order address: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":"error on getting user cart",
+			"error":"error on getting order address",
 		})
-		return 
+		return
 	}
 
+	if err := address.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":err.Error(),
+		})
+		return
+	}
+
+	// Заказ хранит копию адреса: правка или удаление адреса из книги его не затронут
 	order := models.Order {
 		UserID: userID,
-		Status: "Создан",
-		Total: cart.Total,
-		Address: req.Address,
+		Status: models.OrderStatusCreated,
+		Address: address.String(),
+		ShippingAddress: address.AddressFields,
 		Date: time.Now(),
 	}
+	if idempotencyKey != "" {
+		order.IdempotencyKey = &idempotencyKey
+	}
 
-	if err := postgres.DB.Create(&order).Error; err != nil {
-		log.Printf("error on creating order: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":"error on creaing order",
-		})
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var cart models.Cart 
+
+		// Блокируем корзину до конца оформления: параллельный запрос дождётся
+		// коммита и увидит, что корзина уже превращена в заказ
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).Scopes(preloadCartItems).Preload("Coupon").First(&cart).Error; err != nil {
+			return err
+		}
+
+		if len(cart.Items) == 0 {
+			return errCartEmpty
+		}
+
+		var unavailable []uint
+		for _, item := range cart.Items {
+			if !item.Available {
+				unavailable = append(unavailable, item.ProductID)
+			}
+		}
+
+		if len(unavailable) > 0 {
+			return &unavailableProductsError{ProductIDs: unavailable}
+		}
+
+		// Заказ оформляется по текущим ценам, поэтому изменившиеся суммы
+		// пользователь должен подтвердить, передав их в expected_total
+		cart.Revalidate()
+		cart.CalculateTotals(taxRate(), shippingCost())
+
+		if (cart.HasPriceChanges() && req.ExpectedTotal == nil) || (req.ExpectedTotal != nil && !cart.MatchesTotal(*req.ExpectedTotal)) {
+			return &cartChangedError{Warnings: cart.Warnings, Totals: cart.CartTotals}
+		}
+
+		if err := reserveStock(tx, cart.Items); err != nil {
+			return err
+		}
+
+		for _, item := range cart.Items {
+			order.Items = append(order.Items, models.NewOrderItem(item))
+		}
+		order.Shipping = cart.Shipping
+		order.CouponCode = cart.CouponCode
+		order.RecalculateTotal()
+
+		// Позиции сохраняются вместе с заказом
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+
+		// Купон без скидки (например, истёкший) в заказ не попадает и не расходуется
+		if cart.CouponCode != "" {
+			if err := redeemCoupon(tx, *cart.Coupon, order); err != nil {
+				return err
+			}
+		}
+
+		return tx.Delete(&cart).Error
+	})
+
+	if err != nil {
+		// Параллельный запрос с тем же ключом мог успеть оформить заказ первым
+		if idempotencyKey != "" && respondExistingOrder(c, userID, idempotencyKey) {
+			return
+		}
+
+		var stockErr *outOfStockError
+		var unavailableErr *unavailableProductsError
+		var changedErr *cartChangedError
+		var couponErr *couponRejectedError
+
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":"user cart not found",
+			})
+		case errors.Is(err, errCartEmpty):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":err.Error(),
+			})
+		case errors.As(err, &unavailableErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":unavailableErr.Error(),
+				"product_ids":unavailableErr.ProductIDs,
+			})
+		case errors.As(err, &changedErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":changedErr.Error(),
+				"warnings":changedErr.Warnings,
+				"totals":changedErr.Totals,
+			})
+		case errors.As(err, &couponErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":couponErr.Error(),
+			})
+		case errors.As(err, &stockErr):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":stockErr.Error(),
+				"items":stockErr.Items,
+			})
+		default:
+			log.Printf("error on creating order: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":"error on creaing order",
+			})
+		}
 		return 
 	}
 
-	var orderItems []models.OrderItem 
-	for _, item := range cart.Items {
-		orderItems = append(orderItems, models.OrderItem{
-			OrderID: order.ID,
-			ProductID: item.ProductID,
-			Quantity: item.Quantity,
-		})
-	}
+	response := orderCreatedResponse(order)
 
-	if err := postgres.DB.Create(&orderItems).Error; err != nil {
-		log.Printf("error on creating order items %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":"error on creating order items",
-		})
-		return 
+	// Заказ уже создан, поэтому ошибка провайдера его не отменяет:
+	// оплату можно начать заново через /api/order/:order_number/pay
+	if payment, intent, err := createPayment(c.Request.Context(), order); err != nil {
+		log.Printf("error on creating payment for order %s: %v", order.OrderNumber, err)
+	} else {
+		response["payment"] = paymentResponse(payment, intent)
 	}
 
-	if err := postgres.DB.Delete(&cart).Error; err != nil {
-		log.Printf("error on deleting user cart %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":"error on deleting user cart",
-		})
-		return 
+	c.JSON(http.StatusOK, response)
+}
+
+// respondExistingOrder отвечает заказом, уже созданным с этим ключом идемпотентности.
+// Возвращает false, если такого заказа нет.
+func respondExistingOrder(c *gin.Context, userID uint, idempotencyKey string) bool {
+	var order models.Order
+
+	if err := postgres.DB.Where("user_id = ? AND idempotency_key = ?", userID, idempotencyKey).First(&order).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("error on getting order by idempotency key: %v", err)
+		}
+		return false
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(http.StatusOK, orderCreatedResponse(order))
+	return true
+}
+
+func orderCreatedResponse(order models.Order) gin.H {
+	return gin.H{
 		"message":"order successfully created",
 		"order_number":order.OrderNumber,
 		"order_status":order.Status,
 		"date":order.Date.Format("2006-01-02"),
-	})
+		"total":order.Total,
+	}
 }
 
+// GetUserOrders godoc
+// @Summary      Возвращает заказы
+// @Description  Возвращает краткий список заказов пользователя
+// @Tags         Order
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/order/get_all [get]
 func GetUserOrders(c *gin.Context) {
 	userID := c.GetUint("userID")
 
@@ -116,4 +291,175 @@ func GetUserOrders(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"orders": orders,
 	})
-}
\ No newline at end of file
+}
+
+// GetOrder godoc
+// @Summary      Возвращает заказ
+// @Description  Возвращает заказ текущего пользователя по номеру вместе с позициями, снимками товаров и историей статусов
+// @Tags         Order
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param order_number path string true "Номер заказа" example(ORD-2025-0001)
+// @Success      200  {object}  models.Order
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/order/{order_number} [get]
+func GetOrder(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var order models.Order
+
+	// Чужой заказ отдаём как несуществующий, чтобы не раскрывать номера
+	if err := postgres.DB.Preload("Items.Product", models.WithDeletedProducts).Preload("StatusHistory", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	}).Preload("Payments").Preload("Returns").Where("order_number = ? AND user_id = ?", c.Param("order_number"), userID).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":"order not found",
+			})
+			return
+		}
+
+		log.Printf("error on getting order: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":"error on getting order",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+// CancelOrder godoc
+// @Summary      Отменяет заказ
+// @Description  Отменяет заказ текущего пользователя, пока он не передан в сборку. Товары возвращаются на склад, оплата возвращается
+// @Tags         Order
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param order_number path string true "Номер заказа"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/order/{order_number}/cancel [post]
+func CancelOrder(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	changeOrderStatus(c, models.OrderStatusCancelled, "отменён покупателем", func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("order_number = ? AND user_id = ?", c.Param("order_number"), userID)
+	}, func(order models.Order) bool {
+		return order.Status.CancellableByCustomer()
+	})
+}
+
+// UpdateOrderStatus godoc
+// @Summary      Меняет статус заказа
+// @Description  Переводит заказ в новый статус, если переход допустим, и записывает его в историю
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param order_number path string true "Номер заказа"
+// @Param status body models.RequestOrderStatus true "Новый статус"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/orders/{order_number}/status [put]
+func UpdateOrderStatus(c *gin.Context) {
+	var req models.RequestOrderStatus
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":"unknown order status",
+		})
+		return
+	}
+
+	changeOrderStatus(c, req.Status, req.Comment, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("order_number = ?", c.Param("order_number"))
+	}, nil)
+}
+
+// changeOrderStatus блокирует заказ, выбранный scope, проверяет allowed и переход,
+// переводит заказ в статус to и при необходимости возвращает товары на склад
+func changeOrderStatus(c *gin.Context, to models.OrderStatus, comment string, scope func(tx *gorm.DB) *gorm.DB, allowed func(order models.Order) bool) {
+	var order models.Order
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if err := scope(tx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&order).Error; err != nil {
+			return err
+		}
+
+		if allowed != nil && !allowed(order) {
+			return fmt.Errorf("%w: order %s can no longer be changed", models.ErrInvalidStatusTransition, order.OrderNumber)
+		}
+
+		from := order.Status
+		if err := order.Transition(tx, to, c.GetUint("userID"), comment); err != nil {
+			return err
+		}
+
+		if from.RequiresRefund(to) {
+			refunded, err := refundOrderPayments(tx, order.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Model(&order).UpdateColumn("refunded_total", gorm.Expr("refunded_total + ?", refunded)).Error; err != nil {
+				return err
+			}
+		}
+
+		if from.ReleasesStock(to) {
+			if err := releaseCoupon(tx, order.ID); err != nil {
+				return err
+			}
+
+			var items []models.OrderItem
+			if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+				return err
+			}
+
+			return releaseStock(tx, items)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":"order not found",
+			})
+		case errors.Is(err, models.ErrInvalidStatusTransition):
+			c.JSON(http.StatusConflict, gin.H{
+				"error":err.Error(),
+			})
+		case errors.Is(err, errPaymentProvider):
+			log.Printf("error on refunding order: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":"error on refunding order payment",
+			})
+		default:
+			log.Printf("error on changing order status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":"error on changing order status",
+			})
+		}
+		return
+	}
+
+	// Возвраты уже зафиксированы вместе со статусом, теперь их можно отправить
+	sendPendingRefunds(c.Request.Context())
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":"order status changed successfully",
+		"order_number":order.OrderNumber,
+		"order_status":order.Status,
+	})
+}
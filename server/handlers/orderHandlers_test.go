@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	"kotoshop/models"
+	"kotoshop/payments"
+	"kotoshop/postgres"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openTestDB подключается к базе из POSTGRES_STRING. Без неё тест пропускается:
+// тесты с базой меняют данные, поэтому запускать их стоит на отдельной базе
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	postgresString := os.Getenv("POSTGRES_STRING")
+	if postgresString == "" {
+		t.Skip("POSTGRES_STRING is not set")
+	}
+
+	postgres.Open(postgresString)
+	payments.Open("fake", "test-webhook-secret", postgres.DB)
+	gin.SetMode(gin.TestMode)
+}
+
+func TestCreateOrderConcurrentNumbers(t *testing.T) {
+	openTestDB(t)
+
+	const parallel = 20
+	suffix := time.Now().UnixNano()
+
+	product := models.Product{Title: "Order numbering test", Price: 100, Category: "test", Stock: parallel}
+	if err := postgres.DB.Create(&product).Error; err != nil {
+		t.Fatalf("creating product: %v", err)
+	}
+	variant := models.ProductVariant{
+		ProductID:  product.ID,
+		SKU:        fmt.Sprintf("TEST-ORDER-%d", suffix),
+		Price:      100,
+		Stock:      parallel,
+		Attributes: map[string]interface{}{},
+		IsDefault:  true,
+	}
+	if err := postgres.DB.Create(&variant).Error; err != nil {
+		t.Fatalf("creating variant: %v", err)
+	}
+
+	userIDs := make([]uint, parallel)
+	addressIDs := make([]uint, parallel)
+	for i := range userIDs {
+		user := models.User{Email: fmt.Sprintf("order-test-%d-%d@example.com", suffix, i), Password: "-"}
+		if err := postgres.DB.Create(&user).Error; err != nil {
+			t.Fatalf("creating user: %v", err)
+		}
+		userIDs[i] = user.ID
+
+		address := models.Address{UserID: user.ID, AddressFields: models.AddressFields{
+			RecipientName: "Test", Phone: "+79990000000", Country: "Россия", City: "Москва",
+			Street: "Тестовая", Building: "1", PostalCode: "101000",
+		}}
+		if err := postgres.DB.Create(&address).Error; err != nil {
+			t.Fatalf("creating address: %v", err)
+		}
+		addressIDs[i] = address.ID
+
+		cart := models.Cart{UserID: user.ID, Items: []models.CartItem{{
+			ProductID: product.ID,
+			VariantID: variant.ID,
+			Quantity:  1,
+			Price:     variant.Price,
+		}}}
+		if err := postgres.DB.Create(&cart).Error; err != nil {
+			t.Fatalf("creating cart: %v", err)
+		}
+	}
+
+	t.Cleanup(func() {
+		orders := postgres.DB.Model(&models.Order{}).Select("id").Where("user_id IN ?", userIDs)
+		for _, statement := range []struct {
+			query string
+			arg   interface{}
+		}{
+			{"DELETE FROM payments WHERE order_id IN (?)", orders},
+			{"DELETE FROM order_status_events WHERE order_id IN (?)", orders},
+			{"DELETE FROM order_items WHERE order_id IN (?)", orders},
+			{"DELETE FROM orders WHERE user_id IN ?", userIDs},
+			{"DELETE FROM cart_items WHERE cart_id IN (SELECT id FROM carts WHERE user_id IN ?)", userIDs},
+			{"DELETE FROM carts WHERE user_id IN ?", userIDs},
+			{"DELETE FROM addresses WHERE user_id IN ?", userIDs},
+			{"DELETE FROM users WHERE id IN ?", userIDs},
+			{"DELETE FROM product_variants WHERE id = ?", variant.ID},
+			{"DELETE FROM products WHERE id = ?", product.ID},
+		} {
+			if err := postgres.DB.Exec(statement.query, statement.arg).Error; err != nil {
+				t.Logf("cleanup %q: %v", statement.query, err)
+			}
+		}
+	})
+
+	router := gin.New()
+	router.POST("/api/order/create", func(c *gin.Context) {
+		userID, _ := strconv.ParseUint(c.GetHeader("X-Test-User"), 10, 64)
+		c.Set("userID", uint(userID))
+	}, CreateOrder)
+
+	// Все запросы стартуют одновременно, чтобы столкнуться на счётчике номеров
+	start := make(chan struct{})
+	responses := make([]*httptest.ResponseRecorder, parallel)
+
+	var wg sync.WaitGroup
+	for i := range userIDs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			body := fmt.Sprintf(`{"address_id": %d}`, addressIDs[i])
+			req := httptest.NewRequest(http.MethodPost, "/api/order/create", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Test-User", strconv.FormatUint(uint64(userIDs[i]), 10))
+
+			<-start
+			responses[i] = httptest.NewRecorder()
+			router.ServeHTTP(responses[i], req)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, response := range responses {
+		if response.Code != http.StatusOK {
+			t.Fatalf("order %d: status %d: %s", i, response.Code, response.Body.String())
+		}
+	}
+
+	var numbers []string
+	if err := postgres.DB.Model(&models.Order{}).Where("user_id IN ?", userIDs).Pluck("order_number", &numbers).Error; err != nil {
+		t.Fatalf("getting order numbers: %v", err)
+	}
+	if len(numbers) != parallel {
+		t.Fatalf("got %d orders, want %d", len(numbers), parallel)
+	}
+
+	format := regexp.MustCompile(fmt.Sprintf(`^ORD-%d-\d{4,}$`, time.Now().Year()))
+	seen := make(map[string]bool, len(numbers))
+	for _, number := range numbers {
+		if !format.MatchString(number) {
+			t.Errorf("order number %q has unexpected format", number)
+		}
+		if seen[number] {
+			t.Errorf("order number %q issued twice", number)
+		}
+		seen[number] = true
+	}
+}
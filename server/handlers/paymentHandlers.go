@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kotoshop/models"
+	"kotoshop/payments"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errPaymentProvider = errors.New("payment provider error")
+	errUnknownIntent   = errors.New("unknown payment intent")
+	errNothingToRefund = errors.New("nothing to refund")
+)
+
+// createPayment заводит у провайдера платёж на сумму заказа и сохраняет его
+func createPayment(ctx context.Context, order models.Order) (models.Payment, payments.Intent, error) {
+	intent, err := payments.Provider.CreateIntent(ctx, order.OrderNumber, order.Total)
+	if err != nil {
+		return models.Payment{}, intent, fmt.Errorf("%w: %v", errPaymentProvider, err)
+	}
+
+	payment := models.Payment{
+		OrderID: order.ID,
+		Provider: payments.Provider.Name(),
+		IntentID: intent.ID,
+		Amount: intent.Amount,
+		Status: models.PaymentStatusPending,
+	}
+
+	if err := postgres.DB.Create(&payment).Error; err != nil {
+		return payment, intent, err
+	}
+
+	return payment, intent, nil
+}
+
+func paymentResponse(payment models.Payment, intent payments.Intent) gin.H {
+	return gin.H{
+		"provider": payment.Provider,
+		"intent_id": intent.ID,
+		"client_secret": intent.ClientSecret,
+		"amount": payment.Amount,
+	}
+}
+
+// refundPayment записывает возврат amount по платежу в транзакции tx.
+// Провайдеру он уходит только после фиксации транзакции, в sendPendingRefunds
+func refundPayment(tx *gorm.DB, payment *models.Payment, amount float64) error {
+	if amount <= 0 {
+		return nil
+	}
+	if amount > payment.RefundableAmount() {
+		return fmt.Errorf("%w: refund of %.2f exceeds refundable %.2f", errPaymentProvider, amount, payment.RefundableAmount())
+	}
+
+	if err := tx.Create(&models.PaymentRefund{PaymentID: payment.ID, Amount: amount}).Error; err != nil {
+		return err
+	}
+
+	payment.RefundedAmount += amount
+	if payment.RefundedAmount >= payment.Amount {
+		payment.Status = models.PaymentStatusRefunded
+	}
+
+	return tx.Model(payment).Updates(map[string]interface{}{
+		"refunded_amount": payment.RefundedAmount,
+		"status": payment.Status,
+	}).Error
+}
+
+// sendPendingRefunds отправляет провайдеру все ещё не отправленные возвраты.
+// Ошибка одного возврата не мешает остальным: он останется pending и
+// уйдёт при следующем вызове
+func sendPendingRefunds(ctx context.Context) {
+	var pending []models.PaymentRefund
+
+	if err := postgres.DB.Where("status = ?", models.RefundStatusPending).Order("id").Find(&pending).Error; err != nil {
+		log.Printf("error on getting pending refunds: %v", err)
+		return
+	}
+
+	for _, refund := range pending {
+		if err := sendRefund(ctx, refund.ID); err != nil {
+			log.Printf("error on sending refund %d: %v", refund.ID, err)
+		}
+	}
+}
+
+// sendRefund отправляет один возврат. Строка блокируется, чтобы два
+// обработчика не отправили его одновременно
+func sendRefund(ctx context.Context, refundID uint) error {
+	return postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var refund models.PaymentRefund
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("id = ? AND status = ?", refundID, models.RefundStatusPending).First(&refund).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		var payment models.Payment
+		if err := tx.First(&payment, refund.PaymentID).Error; err != nil {
+			return err
+		}
+
+		providerRefundID, err := payments.Provider.Refund(ctx, payment.IntentID, refund.IdempotencyKey(), refund.Amount)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errPaymentProvider, err)
+		}
+
+		return tx.Model(&refund).Updates(map[string]interface{}{
+			"status": models.RefundStatusSucceeded,
+			"provider_refund_id": providerRefundID,
+		}).Error
+	})
+}
+
+// RetryPendingRefunds раз в interval повторяет возвраты, которые не удалось
+// отправить сразу, например из-за недоступности провайдера
+func RetryPendingRefunds(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sendPendingRefunds(ctx)
+		}
+	}
+}
+
+// refundOrderPayments полностью возвращает все оплаченные платежи заказа
+// и отдаёт возвращённую сумму
+func refundOrderPayments(tx *gorm.DB, orderID uint) (float64, error) {
+	var orderPayments []models.Payment
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ? AND status = ?", orderID, models.PaymentStatusPaid).Find(&orderPayments).Error; err != nil {
+		return 0, err
+	}
+
+	var refunded float64
+	for i := range orderPayments {
+		amount := orderPayments[i].RefundableAmount()
+		if err := refundPayment(tx, &orderPayments[i], amount); err != nil {
+			return refunded, err
+		}
+		refunded += amount
+	}
+
+	return refunded, nil
+}
+
+// refundOrderAmount возвращает amount по оплаченным платежам заказа, начиная с самого раннего
+func refundOrderAmount(tx *gorm.DB, orderID uint, amount float64) error {
+	var orderPayments []models.Payment
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ? AND status = ?", orderID, models.PaymentStatusPaid).Order("id").Find(&orderPayments).Error; err != nil {
+		return err
+	}
+
+	remaining := amount
+	for i := range orderPayments {
+		if remaining <= 0 {
+			break
+		}
+
+		part := min(remaining, orderPayments[i].RefundableAmount())
+		if err := refundPayment(tx, &orderPayments[i], part); err != nil {
+			return err
+		}
+		remaining -= part
+	}
+
+	if remaining > 0.005 {
+		return fmt.Errorf("%w: order has no paid amount left to refund %.2f", errNothingToRefund, remaining)
+	}
+
+	return nil
+}
+
+// handlePaymentEvent применяет проверенное событие провайдера к платежу и заказу
+func handlePaymentEvent(ctx context.Context, event payments.WebhookEvent) error {
+	switch event.Type {
+	case payments.EventPaymentAuthorized:
+		if err := payments.Provider.Capture(ctx, event.IntentID); err != nil {
+			return fmt.Errorf("%w: %v", errPaymentProvider, err)
+		}
+		return markPaymentPaid(ctx, event.IntentID)
+	case payments.EventPaymentSucceeded:
+		return markPaymentPaid(ctx, event.IntentID)
+	case payments.EventPaymentFailed:
+		return postgres.DB.Model(&models.Payment{}).Where("intent_id = ? AND status = ?", event.IntentID, models.PaymentStatusPending).Update("status", models.PaymentStatusFailed).Error
+	default:
+		log.Printf("ignoring payment event %s of type %s", event.ID, event.Type)
+		return nil
+	}
+}
+
+func markPaymentPaid(ctx context.Context, intentID string) error {
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var payment models.Payment
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("intent_id = ?", intentID).First(&payment).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errUnknownIntent
+			}
+			return err
+		}
+
+		// Провайдеры повторяют вебхуки, повторное событие ничего не меняет
+		if payment.Status != models.PaymentStatusPending {
+			return nil
+		}
+
+		payment.Status = models.PaymentStatusPaid
+		if err := tx.Model(&payment).Update("status", payment.Status).Error; err != nil {
+			return err
+		}
+
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, payment.OrderID).Error; err != nil {
+			return err
+		}
+
+		// Заказ уже отменён или оплачен другим платежом — деньги возвращаем
+		if order.Status != models.OrderStatusCreated {
+			log.Printf("order %s is %s, refunding payment %s", order.OrderNumber, order.Status, payment.IntentID)
+			return refundPayment(tx, &payment, payment.Amount)
+		}
+
+		return order.Transition(tx, models.OrderStatusPaid, 0, "оплачен через "+payment.Provider)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Возврат за оплату отменённого заказа отправляется после фиксации
+	sendPendingRefunds(ctx)
+	return nil
+}
+
+// StartPayment godoc
+// @Summary      Начинает оплату заказа
+// @Description  Создаёт новый платёж у провайдера для заказа, ожидающего оплаты
+// @Tags         Payments
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param order_number path string true "Номер заказа"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      502  {object}  map[string]string
+// @Router       /api/order/{order_number}/pay [post]
+func StartPayment(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var order models.Order
+
+	if err := postgres.DB.Where("order_number = ? AND user_id = ?", c.Param("order_number"), userID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "order not found",
+		})
+		return
+	}
+
+	if order.Status != models.OrderStatusCreated {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("order is %s and can not be paid", order.Status),
+		})
+		return
+	}
+
+	payment, intent, err := createPayment(c.Request.Context(), order)
+	if err != nil {
+		log.Printf("error on creating payment: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "error on creating payment",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, paymentResponse(payment, intent))
+}
+
+// PaymentWebhook godoc
+// @Summary      Принимает вебхук платёжного провайдера
+// @Description  Проверяет подпись X-Payment-Signature и применяет событие: оплаченный заказ переходит в статус paid
+// @Tags         Payments
+// @Accept       json
+// @Produce      json
+// @Param X-Payment-Signature header string true "Подпись тела запроса"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/payments/webhook [post]
+func PaymentWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "error on reading webhook",
+		})
+		return
+	}
+
+	event, err := payments.Provider.VerifyWebhook(payload, c.GetHeader("X-Payment-Signature"))
+	if err != nil {
+		log.Printf("rejected payment webhook: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid webhook",
+		})
+		return
+	}
+
+	respondPaymentEvent(c, event)
+}
+
+// FakeAuthorizePayment godoc
+// @Summary      Имитирует оплату (только fake-провайдер)
+// @Description  Помечает платёж оплаченным у встроенного fake-провайдера и обрабатывает его подписанный вебхук. Маршрут есть только при PAYMENT_PROVIDER=fake и доступен владельцу заказа
+// @Tags         Payments
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param intent_id path string true "ID платежа"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /api/payments/fake/{intent_id}/authorize [post]
+func FakeAuthorizePayment(c *gin.Context) {
+	fake, ok := payments.Provider.(*payments.FakeProvider)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "fake payment provider is disabled",
+		})
+		return
+	}
+
+	var payment models.Payment
+	if err := postgres.DB.Joins("JOIN orders ON orders.id = payments.order_id").Where("payments.intent_id = ? AND orders.user_id = ?", c.Param("intent_id"), c.GetUint("userID")).First(&payment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "payment not found",
+		})
+		return
+	}
+
+	payload, signature, err := fake.Authorize(c.Request.Context(), payment.IntentID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	event, err := fake.VerifyWebhook(payload, signature)
+	if err != nil {
+		log.Printf("error on verifying fake webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on authorizing payment",
+		})
+		return
+	}
+
+	respondPaymentEvent(c, event)
+}
+
+func respondPaymentEvent(c *gin.Context, event payments.WebhookEvent) {
+	if err := handlePaymentEvent(c.Request.Context(), event); err != nil {
+		log.Printf("error on handling payment event %s: %v", event.ID, err)
+
+		switch {
+		case errors.Is(err, errUnknownIntent):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		case errors.Is(err, errPaymentProvider):
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "error on processing payment",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error on processing payment",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"received": true,
+	})
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const defaultProductsLimit = 20
+
+// productSortColumns сопоставляет значение параметра sort с колонкой выборки
+// и направлением сортировки по умолчанию
+var productSortColumns = map[string]struct {
+	column string
+	desc   bool
+}{
+	"id":             {"p.id", false},
+	"price":          {"p.price", false},
+	"rating":         {"p.rating", true},
+	"feedback_count": {"p.feedback_count", true},
+	"newest":         {"p.created_at", true},
+}
+
+type productListQuery struct {
+	Page      int      `form:"page" binding:"omitempty,min=1"`
+	Limit     int      `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor    string   `form:"cursor"`
+	Category  string   `form:"category"`
+	MinPrice  *float64 `form:"min_price" binding:"omitempty,min=0"`
+	MaxPrice  *float64 `form:"max_price" binding:"omitempty,min=0"`
+	MinRating *float64 `form:"min_rating" binding:"omitempty,min=0,max=5"`
+	Query     string   `form:"q"`
+	Sort      string   `form:"sort" binding:"omitempty,oneof=id price rating feedback_count newest"`
+	Order     string   `form:"order" binding:"omitempty,oneof=asc desc"`
+}
+
+// productCursor указывает на последний отданный товар: значение колонки сортировки и id
+type productCursor struct {
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+func (q *productListQuery) normalize() {
+	if q.Limit == 0 {
+		q.Limit = defaultProductsLimit
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if q.Order == "" {
+		q.Order = "asc"
+		if productSortColumns[q.Sort].desc {
+			q.Order = "desc"
+		}
+	}
+}
+
+// applyFilters добавляет условия фильтрации к выборке из productsWithRating, обёрнутой как p
+func (q *productListQuery) applyFilters(db *gorm.DB) *gorm.DB {
+	if q.Category != "" {
+		// Категория включает товары всех своих подкатегорий
+		db = db.Where("p.category_id IN ("+categorySubtree+")", q.Category)
+	}
+	if q.MinPrice != nil {
+		db = db.Where("p.price >= ?", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		db = db.Where("p.price <= ?", *q.MaxPrice)
+	}
+	if q.MinRating != nil {
+		db = db.Where("p.rating >= ?", *q.MinRating)
+	}
+	if text := strings.TrimSpace(q.Query); text != "" {
+		pattern := "%" + escapeLike(text) + "%"
+		db = db.Where("p.title ILIKE ? OR p.description ILIKE ?", pattern, pattern)
+	}
+	return db
+}
+
+// applyPage сортирует выборку и ограничивает её страницей или курсором.
+// Берётся на одну запись больше limit, чтобы понять, есть ли следующая страница.
+func (q *productListQuery) applyPage(db *gorm.DB) (*gorm.DB, error) {
+	sort := productSortColumns[q.Sort]
+	direction := strings.ToUpper(q.Order)
+
+	if q.Cursor != "" {
+		cursor, value, err := q.decodeCursor()
+		if err != nil {
+			return nil, err
+		}
+
+		operator := ">"
+		if direction == "DESC" {
+			operator = "<"
+		}
+
+		// Рейтинг — numeric, сравниваем его с точным десятичным значением,
+		// а не с float, иначе товары с равным рейтингом пропадут или повторятся
+		placeholder := "?"
+		if q.Sort == "rating" {
+			placeholder = "CAST(? AS numeric)"
+		}
+		db = db.Where(fmt.Sprintf("(%s, p.id) %s (%s, ?)", sort.column, operator, placeholder), value, cursor.ID)
+	} else {
+		db = db.Offset((q.Page - 1) * q.Limit)
+	}
+
+	return db.Order(fmt.Sprintf("%s %s, p.id %s", sort.column, direction, direction)).Limit(q.Limit + 1), nil
+}
+
+func (q *productListQuery) decodeCursor() (productCursor, interface{}, error) {
+	var cursor productCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return cursor, nil, fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID == 0 {
+		return cursor, nil, fmt.Errorf("invalid cursor")
+	}
+
+	var value interface{}
+	switch q.Sort {
+	case "id":
+		value = cursor.ID
+	case "feedback_count":
+		var count int64
+		if err := json.Unmarshal(cursor.Value, &count); err != nil {
+			return cursor, nil, fmt.Errorf("invalid cursor")
+		}
+		value = count
+	case "newest":
+		var createdAt time.Time
+		if err := json.Unmarshal(cursor.Value, &createdAt); err != nil {
+			return cursor, nil, fmt.Errorf("invalid cursor")
+		}
+		value = createdAt
+	case "rating":
+		var rating string
+		if err := json.Unmarshal(cursor.Value, &rating); err != nil {
+			return cursor, nil, fmt.Errorf("invalid cursor")
+		}
+		if _, err := strconv.ParseFloat(rating, 64); err != nil {
+			return cursor, nil, fmt.Errorf("invalid cursor")
+		}
+		value = rating
+	default:
+		var number float64
+		if err := json.Unmarshal(cursor.Value, &number); err != nil {
+			return cursor, nil, fmt.Errorf("invalid cursor")
+		}
+		value = number
+	}
+
+	return cursor, value, nil
+}
+
+// nextCursor строит курсор, указывающий на последний товар страницы
+func (q *productListQuery) nextCursor(last productWithRating) (string, error) {
+	var value interface{}
+	switch q.Sort {
+	case "price":
+		value = last.Price
+	case "rating":
+		// В рейтинге не больше 6 знаков после запятой, поэтому кратчайшая
+		// запись float совпадает с числом из базы
+		value = strconv.FormatFloat(last.Rating, 'f', -1, 64)
+	case "feedback_count":
+		value = last.FeedbackCount
+	case "newest":
+		value = last.CreatedAt
+	}
+
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(productCursor{Value: encodedValue, ID: last.ID})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// prefixTSQuery превращает пользовательский ввод в tsquery, где каждое слово
+// ищется по префиксу: "кот корм" -> "кот:* & корм:*". Пустая строка, если слов нет.
+func prefixTSQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = strings.ToLower(word) + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"kotoshop/models"
 	"kotoshop/postgres"
@@ -8,15 +9,31 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
 )
 
+type productWithRating struct {
+	models.Product
+	Rating float64 `json:"rating"`
+	FeedbackCount uint `json:"feedback_count"`
+	Favorited bool `gorm:"-" json:"favorited"`
+}
+
+// productsWithRating возвращает запрос по неудалённым товарам со средним рейтингом и числом отзывов.
+// Рейтинг округляется до 6 знаков, чтобы курсор мог передать его точное значение.
+func productsWithRating() *gorm.DB {
+	return postgres.DB.Table("products").Select("products.*, ROUND(COALESCE(AVG(feedbacks.rating), 0)::numeric, 6) as rating, COUNT(feedbacks.id) as feedback_count").Joins("LEFT JOIN feedbacks ON feedbacks.product_id = products.id AND feedbacks.deleted_at IS NULL").Where("products.deleted_at IS NULL").Group("products.id")
+}
+
 // CreateProduct godoc
 // @Summary      Добавляет товар
-// @Description  Добавляет новый товар
+// @Description  Добавляет новый товар в существующую категорию, category — её slug. Цена и остаток становятся вариантом по умолчанию с артикулом SKU-{id} (если он занят — SKU-{id}-2 и так далее)
 // @Tags         Products
 // @Accept       json
 // @Produce      json
 // @Param product body models.Product true "Данные о товаре"
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
@@ -33,7 +50,25 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
-	if err := postgres.DB.Create(&product).Error; err != nil {
+	category, err := resolveCategory(product.Category)
+	if err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+	product.Category = category.Slug
+	product.CategoryID = &category.ID
+	// Варианты добавляются отдельно; цена и остаток товара становятся вариантом по умолчанию
+	product.Variants = nil
+
+	err = postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+
+		return createDefaultVariant(tx, product)
+	})
+
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("ошибка при создании товара: %s", err),
 		})
@@ -46,25 +81,102 @@ func CreateProduct(c *gin.Context) {
 	})
 }
 
+// createDefaultVariant создаёт вариант по умолчанию с артикулом SKU-{id}, а если его
+// уже занял вариант другого товара — SKU-{id}-2, SKU-{id}-3 и так далее. Занятость
+// проверяет уникальный индекс при вставке, поэтому параллельно созданные товары
+// не получат один артикул
+func createDefaultVariant(tx *gorm.DB, product models.Product) error {
+	variant := models.ProductVariant{
+		ProductID: product.ID,
+		SKU: fmt.Sprintf("SKU-%d", product.ID),
+		Price: product.Price,
+		Stock: product.Stock,
+		Attributes: map[string]interface{}{},
+		IsDefault: true,
+	}
+
+	for suffix := 2; ; suffix++ {
+		// Ошибка вставки прерывает транзакцию, поэтому каждая попытка идёт
+		// под своей точкой сохранения
+		if err := tx.SavePoint("default_variant").Error; err != nil {
+			return err
+		}
+
+		err := tx.Create(&variant).Error
+		if err == nil {
+			return nil
+		}
+
+		// 23505 — нарушение уникальности
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "23505" || pgErr.ConstraintName != "idx_product_variants_sku" {
+			return err
+		}
+
+		if err := tx.RollbackTo("default_variant").Error; err != nil {
+			return err
+		}
+		variant.ID = 0
+		variant.SKU = fmt.Sprintf("SKU-%d-%d", product.ID, suffix)
+	}
+}
+
 // GetAllProducts godoc
 // @Summary      Возвращает товары
-// @Description  Возвращает список всех товаров магазина
+// @Description  Возвращает страницу товаров магазина с фильтрацией и сортировкой. Для бесконечной прокрутки передавайте next_cursor из предыдущего ответа в cursor. С токеном у товаров проставляется favorited
 // @Tags         Products
 // @Accept       json
 // @Produce      json
+// @Param page query int false "Номер страницы (с 1)"
+// @Param limit query int false "Товаров на странице (1-100, по умолчанию 20)"
+// @Param cursor query string false "Курсор следующей страницы"
+// @Param category query string false "Slug категории, включая её подкатегории"
+// @Param min_price query number false "Минимальная цена"
+// @Param max_price query number false "Максимальная цена"
+// @Param min_rating query number false "Минимальный рейтинг"
+// @Param q query string false "Поиск по названию и описанию"
+// @Param sort query string false "Сортировка: id, price, rating, feedback_count, newest"
+// @Param order query string false "Направление сортировки: asc, desc"
+// @Param Authorization header string false "Токен в формате Bearer {token}" default(Bearer )
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string
 // @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /api/products/get_all [get]
 func GetAllProducts(c *gin.Context) {
-	var products []struct {
-		models.Product
-		Rating float64 `json:"rating"`
-		FeedbackCount uint `json:"feedback_count"`
+	var query productListQuery
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid query parameters: %s", err),
+		})
+		return
 	}
+	query.normalize()
 
-	if err := postgres.DB.Table("products").Select("products.*, COALESCE(AVG(feedbacks.rating), 0) as rating, COUNT(feedbacks.id) as feedback_count").Joins("LEFT JOIN feedbacks ON feedbacks.product_id = products.id").Group("products.id").Order("products.id ASC").Scan(&products).Error; err != nil {
+	filtered := query.applyFilters(postgres.DB.Table("(?) AS p", productsWithRating()))
+
+	var total int64
+	if err := filtered.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		log.Printf("error on counting products: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при получении списка товаров",
+		})
+		return
+	}
+
+	paged, err := query.applyPage(filtered.Session(&gorm.Session{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	products := []productWithRating{}
+
+	if err := paged.Select("p.*").Scan(&products).Error; err != nil {
 			log.Printf("error on extracting products: %s", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 					"error": "Ошибка при получении списка товаров",
@@ -72,6 +184,402 @@ func GetAllProducts(c *gin.Context) {
 			return
 	}
 
-	c.JSON(http.StatusOK, products)
+	var nextCursor string
+	if len(products) > query.Limit {
+		products = products[:query.Limit]
+
+		if nextCursor, err = query.nextCursor(products[len(products)-1]); err != nil {
+			log.Printf("error on building cursor: %s", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Ошибка при получении списка товаров",
+			})
+			return
+		}
+	}
+
+	if err := markFavorited(c.GetUint("userID"), products); err != nil {
+		log.Printf("error on marking favorited products: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при получении списка товаров",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": products,
+		"total": total,
+		"page": query.Page,
+		"limit": query.Limit,
+		"next_cursor": nextCursor,
+	})
+}
+
+// SearchProducts godoc
+// @Summary      Ищет товары
+// @Description  Полнотекстовый поиск по названию, категории и описанию с учётом русской и английской морфологии. Слова ищутся по префиксу, поэтому поиск подходит для подсказок при вводе
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param q query string true "Поисковый запрос"
+// @Param page query int false "Номер страницы (с 1)"
+// @Param limit query int false "Товаров на странице (1-100, по умолчанию 20)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/search [get]
+func SearchProducts(c *gin.Context) {
+	var query struct {
+		Query string `form:"q"`
+		Page  int    `form:"page" binding:"omitempty,min=1"`
+		Limit int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	}
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid query parameters: %s", err),
+		})
+		return
+	}
+	if query.Page == 0 {
+		query.Page = 1
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultProductsLimit
+	}
+
+	tsQuery := prefixTSQuery(query.Query)
+	if tsQuery == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "search query is required",
+		})
+		return
+	}
+
+	matched := postgres.DB.Table("(?) AS p", productsWithRating()).
+		Joins("CROSS JOIN (SELECT to_tsquery('russian', ?) || to_tsquery('english', ?) AS query) AS q", tsQuery, tsQuery).
+		Where("p.search_vector @@ q.query")
+
+	var total int64
+	if err := matched.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		log.Printf("error on counting search results: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при поиске товаров",
+		})
+		return
+	}
+
+	products := []struct {
+		productWithRating
+		Rank float64 `json:"rank"`
+	}{}
+
+	if err := matched.Select("p.*, ts_rank(p.search_vector, q.query) AS rank").Order("rank DESC, p.id ASC").Offset((query.Page - 1) * query.Limit).Limit(query.Limit).Scan(&products).Error; err != nil {
+		log.Printf("error on searching products: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при поиске товаров",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": products,
+		"total": total,
+		"page": query.Page,
+		"limit": query.Limit,
+	})
 }
 
+// GetProduct godoc
+// @Summary      Возвращает товар
+// @Description  Возвращает товар по id вместе с рейтингом, количеством отзывов, галереей картинок и вариантами. В attributes перечислены характеристики, по которым выбирается вариант, и их значения
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param id path uint true "ID товара"
+// @Param lang query string false "Язык названий характеристик (ru, en), по умолчанию из Accept-Language"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id} [get]
+func GetProduct(c *gin.Context) {
+	var product productWithRating
+
+	result := productsWithRating().Where("products.id = ?", c.Param("id")).Limit(1).Scan(&product)
+	if result.Error != nil {
+		log.Printf("error on extracting product: %s", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при получении товара",
+		})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "product not found",
+		})
+		return
+	}
+
+	if err := postgres.DB.Where("product_id = ?", product.ID).Order("is_default DESC, id ASC").Find(&product.Variants).Error; err != nil {
+		log.Printf("error on getting product variants: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при получении товара",
+		})
+		return
+	}
+
+	if err := postgres.DB.Where("product_id = ? AND NOT private", product.ID).Order("position ASC, id ASC").Find(&product.Images).Error; err != nil {
+		log.Printf("error on getting product images: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при получении товара",
+		})
+		return
+	}
+
+	definitions, err := categoryAttributes(postgres.DB, product.CategoryID)
+	if err != nil {
+		log.Printf("error on getting product attributes: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Ошибка при получении товара",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, productDetail{
+		productWithRating: product,
+		Attributes: variantMatrix(definitions, product.Variants, requestLanguage(c)),
+	})
+}
+
+// UpdateProduct godoc
+// @Summary      Обновляет товар
+// @Description  Полностью заменяет данные товара. price и stock задаются варианту по умолчанию
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param product body models.RequestProduct true "Данные о товаре"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id} [put]
+func UpdateProduct(c *gin.Context) {
+	var req models.RequestProduct
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "error on parsing product",
+		})
+		return
+	}
+
+	category, err := resolveCategory(req.Category)
+	if err != nil {
+		respondCategoryError(c, err)
+		return
+	}
+
+	updateProductFields(c, map[string]interface{}{
+		"title": req.Title,
+		"price": req.Price,
+		"description": req.Description,
+		"image": req.Image,
+		"category": category.Slug,
+		"category_id": category.ID,
+		"stock": req.Stock,
+	})
+}
+
+// PatchProduct godoc
+// @Summary      Частично обновляет товар
+// @Description  Обновляет только переданные поля товара. price и stock задаются варианту по умолчанию
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param product body models.RequestPatchProduct true "Изменяемые поля товара"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id} [patch]
+func PatchProduct(c *gin.Context) {
+	var req models.RequestPatchProduct
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "error on parsing product",
+		})
+		return
+	}
+
+	fields := map[string]interface{}{}
+	if req.Title != nil {
+		fields["title"] = *req.Title
+	}
+	if req.Price != nil {
+		fields["price"] = *req.Price
+	}
+	if req.Description != nil {
+		fields["description"] = *req.Description
+	}
+	if req.Image != nil {
+		fields["image"] = *req.Image
+	}
+	if req.Category != nil {
+		category, err := resolveCategory(*req.Category)
+		if err != nil {
+			respondCategoryError(c, err)
+			return
+		}
+		fields["category"] = category.Slug
+		fields["category_id"] = category.ID
+	}
+	if req.Stock != nil {
+		fields["stock"] = *req.Stock
+	}
+
+	if len(fields) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "nothing to update",
+		})
+		return
+	}
+
+	updateProductFields(c, fields)
+}
+
+func updateProductFields(c *gin.Context, fields map[string]interface{}) {
+	var product models.Product
+
+	if err := postgres.DB.First(&product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "product not found",
+			})
+			return
+		}
+
+		log.Printf("error on getting product: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting product",
+		})
+		return
+	}
+
+	// Цена и остаток товара считаются по вариантам, поэтому меняется вариант по умолчанию
+	variantFields := map[string]interface{}{}
+	for _, column := range []string{"price", "stock"} {
+		if value, ok := fields[column]; ok {
+			variantFields[column] = value
+			delete(fields, column)
+		}
+	}
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if len(fields) > 0 {
+			if err := tx.Model(&product).Updates(fields).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(variantFields) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&models.ProductVariant{}).Where("product_id = ? AND is_default", product.ID).Updates(variantFields).Error; err != nil {
+			return err
+		}
+		if err := syncProductsFromVariants(tx, []uint{product.ID}); err != nil {
+			return err
+		}
+
+		return tx.First(&product, product.ID).Error
+	})
+
+	if err != nil {
+		log.Printf("error on updating product: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on updating product",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "product updated successfully",
+		"product": product,
+	})
+}
+
+// DeleteProduct godoc
+// @Summary      Удаляет товар
+// @Description  Мягко удаляет товар: он пропадает из каталога, но остаётся в корзинах и заказах как недоступный
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id} [delete]
+func DeleteProduct(c *gin.Context) {
+	result := postgres.DB.Delete(&models.Product{}, c.Param("id"))
+
+	if result.Error != nil {
+		log.Printf("error on deleting product: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on deleting product",
+		})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "product not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "product deleted successfully",
+	})
+}
+
+// RestoreProduct godoc
+// @Summary      Восстанавливает товар
+// @Description  Возвращает мягко удалённый товар в каталог
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/products/{id}/restore [post]
+func RestoreProduct(c *gin.Context) {
+	result := postgres.DB.Unscoped().Model(&models.Product{}).Where("id = ? AND deleted_at IS NOT NULL", c.Param("id")).Update("deleted_at", nil)
+
+	if result.Error != nil {
+		log.Printf("error on restoring product: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on restoring product",
+		})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "deleted product not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "product restored successfully",
+	})
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errInvalidReturn      = errors.New("invalid return request")
+	errOrderNotReturnable = errors.New("returns are accepted only for delivered orders")
+	errReturnResolved     = errors.New("return request is already resolved")
+)
+
+// CreateReturn godoc
+// @Summary      Оформляет возврат
+// @Description  Создаёт заявки на возврат позиций доставленного заказа с причиной и количеством
+// @Tags         Returns
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param order_number path string true "Номер заказа"
+// @Param items body models.RequestCreateReturn true "Возвращаемые позиции"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/order/{order_number}/returns [post]
+func CreateReturn(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req models.RequestCreateReturn
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "error on parsing return request",
+		})
+		return
+	}
+
+	var returns []models.ReturnRequest
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").Where("order_number = ? AND user_id = ?", c.Param("order_number"), userID).First(&order).Error; err != nil {
+			return err
+		}
+
+		if order.Status != models.OrderStatusDelivered {
+			return errOrderNotReturnable
+		}
+
+		items := make(map[uint]models.OrderItem, len(order.Items))
+		for _, item := range order.Items {
+			items[item.ID] = item
+		}
+
+		// Сколько единиц каждой позиции уже заявлено к возврату (кроме отклонённых)
+		requested := map[uint]uint{}
+		var existing []models.ReturnRequest
+		if err := tx.Where("order_id = ? AND status <> ?", order.ID, models.ReturnStatusRejected).Find(&existing).Error; err != nil {
+			return err
+		}
+		for _, ret := range existing {
+			requested[ret.OrderItemID] += ret.Quantity
+		}
+
+		for _, reqItem := range req.Items {
+			item, ok := items[reqItem.OrderItemID]
+			if !ok {
+				return fmt.Errorf("%w: order item %d not found", errInvalidReturn, reqItem.OrderItemID)
+			}
+
+			requested[item.ID] += reqItem.Quantity
+			if requested[item.ID] > item.Quantity {
+				return fmt.Errorf("%w: only %d of %q can be returned", errInvalidReturn, item.Quantity-(requested[item.ID]-reqItem.Quantity), item.Title)
+			}
+
+			returns = append(returns, models.ReturnRequest{
+				OrderID: order.ID,
+				OrderItemID: item.ID,
+				UserID: userID,
+				Quantity: reqItem.Quantity,
+				Reason: reqItem.Reason,
+				Status: models.ReturnStatusRequested,
+			})
+		}
+
+		return tx.Create(&returns).Error
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "order not found",
+			})
+		case errors.Is(err, errInvalidReturn):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		case errors.Is(err, errOrderNotReturnable):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		default:
+			log.Printf("error on creating return: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error on creating return",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "return requested successfully",
+		"returns": returns,
+	})
+}
+
+// GetReturns godoc
+// @Summary      Возвращает заявки на возврат
+// @Description  Возвращает заявки на возврат, по умолчанию — ожидающие решения
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param status query string false "Статус заявки: requested, approved, rejected"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/admin/returns [get]
+func GetReturns(c *gin.Context) {
+	status := c.DefaultQuery("status", string(models.ReturnStatusRequested))
+
+	returns := []struct {
+		models.ReturnRequest
+		OrderNumber string `json:"order_number"`
+		Title string `json:"title"`
+		UnitPrice float64 `json:"unit_price"`
+	}{}
+
+	if err := postgres.DB.Table("return_requests").Select("return_requests.*, orders.order_number, order_items.title, order_items.unit_price").Joins("JOIN orders ON orders.id = return_requests.order_id").Joins("JOIN order_items ON order_items.id = return_requests.order_item_id").Where("return_requests.deleted_at IS NULL AND return_requests.status = ?", status).Order("return_requests.id ASC").Scan(&returns).Error; err != nil {
+		log.Printf("error on getting returns: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting returns",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"returns": returns,
+	})
+}
+
+// ResolveReturn godoc
+// @Summary      Решает заявку на возврат
+// @Description  Одобряет или отклоняет возврат. Одобренный возврат возвращает деньги через платёжного провайдера, товар на склад и уменьшает сумму заказа
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID заявки"
+// @Param decision body models.RequestResolveReturn true "Решение"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      502  {object}  map[string]string
+// @Router       /api/admin/returns/{id} [put]
+func ResolveReturn(c *gin.Context) {
+	var req models.RequestResolveReturn
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be approved or rejected",
+		})
+		return
+	}
+
+	var ret models.ReturnRequest
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ret, c.Param("id")).Error; err != nil {
+			return err
+		}
+
+		if ret.Status != models.ReturnStatusRequested {
+			return errReturnResolved
+		}
+
+		ret.Status = req.Status
+		ret.AdminComment = req.Comment
+		ret.ResolvedByID = c.GetUint("userID")
+
+		if req.Status == models.ReturnStatusApproved {
+			if err := approveReturn(c, tx, &ret); err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&ret).Select("status", "admin_comment", "resolved_by_id", "refund_amount").Updates(&ret).Error
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "return request not found",
+			})
+		case errors.Is(err, errReturnResolved), errors.Is(err, errNothingToRefund), errors.Is(err, models.ErrInvalidStatusTransition):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		case errors.Is(err, errPaymentProvider):
+			log.Printf("error on refunding return: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "error on refunding return",
+			})
+		default:
+			log.Printf("error on resolving return: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "error on resolving return",
+			})
+		}
+		return
+	}
+
+	sendPendingRefunds(c.Request.Context())
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "return resolved successfully",
+		"return": ret,
+	})
+}
+
+// approveReturn возвращает деньги за позицию, кладёт товар обратно на склад
+// и переводит заказ в refunded, когда вернули всё
+func approveReturn(c *gin.Context, tx *gorm.DB, ret *models.ReturnRequest) error {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, ret.OrderID).Error; err != nil {
+		return err
+	}
+
+	var item models.OrderItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, ret.OrderItemID).Error; err != nil {
+		return err
+	}
+
+	ret.RefundAmount = item.RefundFor(ret.Quantity)
+	if err := refundOrderAmount(tx, order.ID, ret.RefundAmount); err != nil {
+		return err
+	}
+
+	if err := releaseStock(tx, []models.OrderItem{{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: ret.Quantity}}); err != nil {
+		return err
+	}
+
+	if err := tx.Model(&item).UpdateColumn("returned_quantity", gorm.Expr("returned_quantity + ?", ret.Quantity)).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&order).UpdateColumn("refunded_total", gorm.Expr("refunded_total + ?", ret.RefundAmount)).Error; err != nil {
+		return err
+	}
+
+	var notReturned int64
+	if err := tx.Model(&models.OrderItem{}).Where("order_id = ? AND returned_quantity < quantity", order.ID).Count(&notReturned).Error; err != nil {
+		return err
+	}
+
+	if notReturned == 0 {
+		return order.Transition(tx, models.OrderStatusRefunded, ret.ResolvedByID, "все позиции возвращены")
+	}
+
+	return nil
+}
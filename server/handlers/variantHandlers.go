@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errVariantNotFound = errors.New("variant not found")
+	errLastVariant = errors.New("product must keep at least one variant")
+)
+
+// variantAttribute — ось матрицы вариантов: характеристика и значения,
+// которые встречаются у вариантов товара
+type variantAttribute struct {
+	Code string `json:"code" example:"size"`
+	Name string `json:"name" example:"Размер"`
+	Type models.AttributeType `json:"type" example:"enum"`
+	Values []interface{} `json:"values"`
+}
+
+// productDetail — товар с вариантами и осями для выбора варианта в интерфейсе
+type productDetail struct {
+	productWithRating
+	Attributes []variantAttribute `json:"attributes"`
+}
+
+// resolveVariant находит вариант неудалённого товара. Без variantID
+// возвращается вариант по умолчанию.
+func resolveVariant(tx *gorm.DB, productID uint, variantID uint) (models.ProductVariant, error) {
+	var variant models.ProductVariant
+
+	var product models.Product
+	if err := tx.Select("id").First(&product, productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return variant, fmt.Errorf("%w: %d", errProductNotFound, productID)
+		}
+		return variant, err
+	}
+
+	query := tx.Where("product_id = ?", productID)
+	if variantID != 0 {
+		query = query.Where("id = ?", variantID)
+	} else {
+		query = query.Where("is_default")
+	}
+
+	if err := query.First(&variant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return variant, fmt.Errorf("%w: %d", errVariantNotFound, variantID)
+		}
+		return variant, err
+	}
+
+	return variant, nil
+}
+
+// categoryAttributes возвращает характеристики категории вместе с унаследованными
+// от родительских категорий
+func categoryAttributes(tx *gorm.DB, categoryID *uint) ([]models.AttributeDefinition, error) {
+	definitions := []models.AttributeDefinition{}
+	if categoryID == nil {
+		return definitions, nil
+	}
+
+	err := tx.Where(`category_id IN (WITH RECURSIVE ancestors AS (
+		SELECT id, parent_id FROM categories WHERE id = ?
+		UNION
+		SELECT categories.id, categories.parent_id FROM categories JOIN ancestors ON categories.id = ancestors.parent_id
+	) SELECT id FROM ancestors)`, *categoryID).Order("sort_order ASC, id ASC").Find(&definitions).Error
+
+	return definitions, err
+}
+
+// variantMatrix собирает оси выбора варианта: для каждой характеристики
+// значения в порядке Options (для enum) или первого появления у вариантов
+func variantMatrix(definitions []models.AttributeDefinition, variants []models.ProductVariant, lang string) []variantAttribute {
+	attributes := []variantAttribute{}
+
+	for i := range definitions {
+		definition := &definitions[i]
+
+		seen := map[string]bool{}
+		var used []interface{}
+		for _, variant := range variants {
+			value, ok := variant.Attributes[definition.Code]
+			if !ok || value == nil || seen[fmt.Sprint(value)] {
+				continue
+			}
+			seen[fmt.Sprint(value)] = true
+			used = append(used, value)
+		}
+
+		if len(used) == 0 {
+			continue
+		}
+
+		values := used
+		if definition.Type == models.AttributeTypeEnum {
+			values = []interface{}{}
+			for _, option := range definition.Options {
+				if seen[option] {
+					values = append(values, option)
+				}
+			}
+		}
+
+		attributes = append(attributes, variantAttribute{
+			Code: definition.Code,
+			Name: definition.LocalizedName(lang),
+			Type: definition.Type,
+			Values: values,
+		})
+	}
+
+	return attributes
+}
+
+// loadProduct находит неудалённый товар из пути запроса.
+// Если товара нет, сам отвечает клиенту и возвращает false.
+func loadProduct(c *gin.Context, product *models.Product) bool {
+	if err := postgres.DB.First(product, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "product not found",
+			})
+			return false
+		}
+
+		log.Printf("error on getting product: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting product",
+		})
+		return false
+	}
+	return true
+}
+
+// saveVariant проверяет характеристики варианта по категории товара, сохраняет его,
+// следит за единственным вариантом по умолчанию и пересчитывает цену и остаток товара
+func saveVariant(tx *gorm.DB, product models.Product, variant *models.ProductVariant) error {
+	definitions, err := categoryAttributes(tx, product.CategoryID)
+	if err != nil {
+		return err
+	}
+	if err := models.ValidateAttributes(definitions, variant.Attributes); err != nil {
+		return err
+	}
+
+	var others int64
+	if err := tx.Model(&models.ProductVariant{}).Where("product_id = ? AND id <> ?", product.ID, variant.ID).Count(&others).Error; err != nil {
+		return err
+	}
+	if others == 0 {
+		variant.IsDefault = true
+	}
+
+	if variant.IsDefault {
+		if err := tx.Model(&models.ProductVariant{}).Where("product_id = ? AND id <> ? AND is_default", product.ID, variant.ID).Update("is_default", false).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Save(variant).Error; err != nil {
+		return err
+	}
+
+	return syncProductsFromVariants(tx, []uint{product.ID})
+}
+
+// bindVariant разбирает вариант из запроса и проверяет уникальность артикула.
+// При ошибке сам отвечает клиенту и возвращает false.
+func bindVariant(c *gin.Context, variant *models.ProductVariant) bool {
+	var req models.RequestVariant
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("error on parsing variant: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid variant data",
+		})
+		return false
+	}
+
+	sku := strings.ToUpper(strings.TrimSpace(req.SKU))
+
+	var duplicates int64
+	if err := postgres.DB.Model(&models.ProductVariant{}).Where("sku = ? AND id <> ?", sku, variant.ID).Count(&duplicates).Error; err != nil {
+		log.Printf("error on checking variant sku: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error on checking variant sku"})
+		return false
+	}
+	if duplicates > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "sku already exists"})
+		return false
+	}
+
+	variant.SKU = sku
+	variant.Price = req.Price
+	variant.Stock = req.Stock
+	variant.Image = req.Image
+	variant.Attributes = req.Attributes
+	if variant.Attributes == nil {
+		variant.Attributes = map[string]interface{}{}
+	}
+	// Снять признак по умолчанию можно, только выбрав другой вариант
+	variant.IsDefault = variant.IsDefault || req.IsDefault
+
+	return true
+}
+
+func respondVariantError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidAttributes):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, errVariantNotFound), errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "variant not found",
+		})
+	case errors.Is(err, errLastVariant):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		log.Printf("error on %s variant: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("error on %s variant", action),
+		})
+	}
+}
+
+// CreateVariant godoc
+// @Summary      Добавляет вариант товара
+// @Description  Добавляет вариант со своими артикулом, ценой, остатком и характеристиками. Характеристики проверяются по определениям категории товара
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param variant body models.RequestVariant true "Вариант"
+// @Success      201  {object}  models.ProductVariant
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id}/variants [post]
+func CreateVariant(c *gin.Context) {
+	var product models.Product
+	if !loadProduct(c, &product) {
+		return
+	}
+
+	variant := models.ProductVariant{ProductID: product.ID}
+	if !bindVariant(c, &variant) {
+		return
+	}
+
+	if err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		return saveVariant(tx, product, &variant)
+	}); err != nil {
+		respondVariantError(c, err, "creating")
+		return
+	}
+
+	c.JSON(http.StatusCreated, variant)
+}
+
+// UpdateVariant godoc
+// @Summary      Обновляет вариант товара
+// @Description  Полностью заменяет артикул, цену, остаток и характеристики варианта. Корзины увидят новую цену как предупреждение
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param variant_id path uint true "ID варианта"
+// @Param variant body models.RequestVariant true "Вариант"
+// @Success      200  {object}  models.ProductVariant
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id}/variants/{variant_id} [put]
+func UpdateVariant(c *gin.Context) {
+	var product models.Product
+	if !loadProduct(c, &product) {
+		return
+	}
+
+	var variant models.ProductVariant
+	if err := postgres.DB.Where("id = ? AND product_id = ?", c.Param("variant_id"), product.ID).First(&variant).Error; err != nil {
+		respondVariantError(c, err, "getting")
+		return
+	}
+
+	if !bindVariant(c, &variant) {
+		return
+	}
+
+	if err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		return saveVariant(tx, product, &variant)
+	}); err != nil {
+		respondVariantError(c, err, "updating")
+		return
+	}
+
+	c.JSON(http.StatusOK, variant)
+}
+
+// DeleteVariant godoc
+// @Summary      Удаляет вариант товара
+// @Description  Мягко удаляет вариант: в корзинах он станет недоступным, заказы сохранят снимок. Последний вариант товара удалить нельзя; вместо удалённого варианта по умолчанию им становится другой
+// @Tags         Products
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param id path uint true "ID товара"
+// @Param variant_id path uint true "ID варианта"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/products/{id}/variants/{variant_id} [delete]
+func DeleteVariant(c *gin.Context) {
+	var product models.Product
+	if !loadProduct(c, &product) {
+		return
+	}
+
+	err := postgres.DB.Transaction(func(tx *gorm.DB) error {
+		var variant models.ProductVariant
+		if err := tx.Where("id = ? AND product_id = ?", c.Param("variant_id"), product.ID).First(&variant).Error; err != nil {
+			return err
+		}
+
+		var next models.ProductVariant
+		if err := tx.Where("product_id = ? AND id <> ?", product.ID, variant.ID).Order("id").First(&next).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errLastVariant
+			}
+			return err
+		}
+
+		if err := tx.Delete(&variant).Error; err != nil {
+			return err
+		}
+
+		if variant.IsDefault {
+			if err := tx.Model(&next).Update("is_default", true).Error; err != nil {
+				return err
+			}
+		}
+
+		return syncProductsFromVariants(tx, []uint{product.ID})
+	})
+
+	if err != nil {
+		respondVariantError(c, err, "deleting")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "variant deleted successfully",
+	})
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"kotoshop/models"
+	"kotoshop/postgres"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errNotInWishlist = errors.New("product is not in wishlist")
+
+// markFavorited проставляет Favorited у товаров из избранного пользователя.
+// Для анонимных запросов (userID = 0) ничего не делает.
+func markFavorited(userID uint, products []productWithRating) error {
+	if userID == 0 || len(products) == 0 {
+		return nil
+	}
+
+	productIDs := make([]uint, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+
+	var favorited []uint
+	if err := postgres.DB.Model(&models.Wishlist{}).Where("user_id = ? AND product_id IN ?", userID, productIDs).Pluck("product_id", &favorited).Error; err != nil {
+		return err
+	}
+
+	favoritedSet := make(map[uint]bool, len(favorited))
+	for _, id := range favorited {
+		favoritedSet[id] = true
+	}
+
+	for i := range products {
+		products[i].Favorited = favoritedSet[products[i].ID]
+	}
+
+	return nil
+}
+
+// GetWishlist godoc
+// @Summary      Возвращает избранное
+// @Description  Возвращает избранные товары пользователя, новые сверху. Снятые с продажи товары помечены available=false
+// @Tags         Wishlist
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/wishlist [get]
+func GetWishlist(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	items := []models.Wishlist{}
+
+	if err := postgres.DB.Preload("Product", models.WithDeletedProducts).Where("user_id = ?", userID).Order("created_at DESC").Find(&items).Error; err != nil {
+		log.Printf("error on getting wishlist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on getting wishlist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": items,
+	})
+}
+
+// AddToWishlist godoc
+// @Summary      Добавляет товар в избранное
+// @Description  Добавляет товар в избранное пользователя. Повторное добавление ничего не меняет
+// @Tags         Wishlist
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param item body models.RequestWishlistItem true "Товар"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/wishlist [post]
+func AddToWishlist(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	var req models.RequestWishlistItem
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "product_id is required",
+		})
+		return
+	}
+
+	var product models.Product
+	if err := postgres.DB.First(&product, req.ProductID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "product not found",
+		})
+		return
+	}
+
+	item := models.Wishlist{UserID: userID, ProductID: product.ID}
+	if err := postgres.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&item).Error; err != nil {
+		log.Printf("error on adding to wishlist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on adding to wishlist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "product added to wishlist",
+	})
+}
+
+// RemoveFromWishlist godoc
+// @Summary      Убирает товар из избранного
+// @Description  Убирает товар из избранного пользователя
+// @Tags         Wishlist
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param product_id path uint true "ID товара"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/wishlist/{product_id} [delete]
+func RemoveFromWishlist(c *gin.Context) {
+	productID, ok := parseProductIDParam(c)
+	if !ok {
+		return
+	}
+
+	result := postgres.DB.Where("user_id = ? AND product_id = ?", c.GetUint("userID"), productID).Delete(&models.Wishlist{})
+	if result.Error != nil {
+		log.Printf("error on removing from wishlist: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "error on removing from wishlist",
+		})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": errNotInWishlist.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "product removed from wishlist",
+	})
+}
+
+// MoveWishlistToCart godoc
+// @Summary      Переносит товар из избранного в корзину
+// @Description  Добавляет товар (вариант variant_id или вариант по умолчанию) в корзину с проверкой остатков и убирает его из избранного. Возвращает пересчитанную корзину
+// @Tags         Wishlist
+// @Accept       json
+// @Produce      json
+// @Param Authorization header string true "Токен в формате Bearer {token}" default(Bearer )
+// @Param product_id path uint true "ID товара"
+// @Param quantity body models.RequestMoveToCart false "Количество, по умолчанию 1, и вариант"
+// @Success      200  {object}  models.Cart
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]string
+// @Router       /api/wishlist/{product_id}/move_to_cart [post]
+func MoveWishlistToCart(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	productID, ok := parseProductIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req models.RequestMoveToCart
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "quantity must be positive",
+			})
+			return
+		}
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	updateCart(c, func(tx *gorm.DB, cart models.Cart) error {
+		result := tx.Where("user_id = ? AND product_id = ?", userID, productID).Delete(&models.Wishlist{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errNotInWishlist
+		}
+
+		variant, err := resolveVariant(tx, productID, req.VariantID)
+		if err != nil {
+			return err
+		}
+
+		quantity, err := cartItemQuantity(tx, cart.ID, variant.ID)
+		if err != nil {
+			return err
+		}
+
+		return setCartItemQuantity(tx, cart.ID, productID, variant.ID, quantity+req.Quantity)
+	})
+}
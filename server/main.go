@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"kotoshop/handlers"
+	"kotoshop/models"
+	"kotoshop/payments"
 	"kotoshop/postgres"
+	"kotoshop/storage"
+	"kotoshop/thumbnails"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	_ "kotoshop/docs"
@@ -27,39 +33,108 @@ func main() {
         log.Fatal("Error loading .env file")
     }
 	postgres.Open(os.Getenv("POSTGRES_STRING"))
+	payments.Open(os.Getenv("PAYMENT_PROVIDER"), os.Getenv("PAYMENT_WEBHOOK_SECRET"), postgres.DB)
+	storage.Open(os.Getenv("BLOB_STORE"))
+	handlers.SetImageURLSecret(os.Getenv("IMAGE_URL_SECRET"))
+	// Возвраты, которые не удалось отправить провайдеру сразу, повторяются в фоне
+	go handlers.RetryPendingRefunds(context.Background(), time.Minute)
+	// Без THUMBNAIL_CACHE_SIZE_MB кэш миниатюр ограничен 1 ГБ
+	cacheSize, _ := strconv.ParseInt(os.Getenv("THUMBNAIL_CACHE_SIZE_MB"), 10, 64)
+	thumbnails.Open(os.Getenv("THUMBNAIL_DIR"), cacheSize<<20)
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173"}, 
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Idempotency-Key", "X-Cart-Token", "Range"},
+		ExposeHeaders:    []string{"Content-Length", "X-Cart-Token", "Content-Range", "Accept-Ranges", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	r.POST("/api/auth/signup", handlers.Signup)
 	r.POST("/api/auth/login", handlers.Login)
+	r.POST("/api/auth/refresh", handlers.RefreshToken)
+	r.POST("/api/auth/logout", handlers.AuthMiddleware, handlers.Logout)
 	r.GET("/api/auth/profile", handlers.AuthMiddleware,handlers.Profile)
 	r.PUT("/api/auth/update", handlers.AuthMiddleware, handlers.UpdateUser)
 
-	r.POST("/api/products/post", handlers.CreateProduct)
-	r.GET("/api/products/get_all", handlers.GetAllProducts)
+	r.POST("/api/products/post", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.CreateProduct)
+	r.GET("/api/products/get_all", handlers.OptionalAuthMiddleware, handlers.GetAllProducts)
+	r.GET("/api/products/search", handlers.SearchProducts)
+	r.GET("/api/products/:id", handlers.GetProduct)
+	r.PUT("/api/products/:id", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.UpdateProduct)
+	r.PATCH("/api/products/:id", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.PatchProduct)
+	r.DELETE("/api/products/:id", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.DeleteProduct)
+	r.POST("/api/products/:id/variants", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.CreateVariant)
+	r.PUT("/api/products/:id/variants/:variant_id", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.UpdateVariant)
+	r.DELETE("/api/products/:id/variants/:variant_id", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.DeleteVariant)
+	r.POST("/api/products/:id/images", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.UploadProductImage)
+	r.PUT("/api/products/:id/images/order", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.ReorderProductImages)
+	r.DELETE("/api/products/:id/images/:image_id", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.DeleteProductImage)
+	r.GET("/api/products/:id/images/:image_id/signed_url", handlers.AuthMiddleware, handlers.RequireRole(models.RoleManager, models.RoleAdmin), handlers.GetSignedImageURL)
+
+	r.GET("/api/categories", handlers.GetCategories)
+	r.GET("/api/categories/:id/attributes", handlers.GetCategoryAttributes)
 
 	r.POST("/api/feedback/post", handlers.AuthMiddleware, handlers.PostFeedback)
 	r.GET("/api/feedback/get_all", handlers.GetFeedbacks)
 	r.GET("/api/feedback/get_feedback", handlers.AuthMiddleware, handlers.GetUserFeedback)
 	r.PUT("/api/feedback/update_feedback", handlers.AuthMiddleware, handlers.UpdateFeedback)
 
-	r.POST("/api/cart/add_product", handlers.AuthMiddleware, handlers.AddToCart)
-	r.GET("/api/cart/get_cart", handlers.AuthMiddleware, handlers.GetCart)
-	r.PUT("/api/cart/remove_product", handlers.AuthMiddleware, handlers.DeleteCartItem)
-	r.DELETE("/api/cart/clean_cart", handlers.AuthMiddleware, handlers.CleanCart)
+	r.POST("/api/cart/add_product", handlers.CartSessionMiddleware, handlers.AddToCart)
+	r.GET("/api/cart/get_cart", handlers.CartSessionMiddleware, handlers.GetCart)
+	r.PUT("/api/cart/remove_product", handlers.CartSessionMiddleware, handlers.DeleteCartItem)
+	r.DELETE("/api/cart/clean_cart", handlers.CartSessionMiddleware, handlers.CleanCart)
+	r.PUT("/api/cart/items", handlers.CartSessionMiddleware, handlers.BulkUpdateCart)
+	r.PUT("/api/cart/items/:product_id", handlers.CartSessionMiddleware, handlers.SetCartItem)
+	r.DELETE("/api/cart/items/:product_id", handlers.CartSessionMiddleware, handlers.RemoveCartLine)
+	r.POST("/api/cart/apply_coupon", handlers.CartSessionMiddleware, handlers.ApplyCoupon)
+	r.DELETE("/api/cart/apply_coupon", handlers.CartSessionMiddleware, handlers.RemoveCoupon)
+
+	r.GET("/api/wishlist", handlers.AuthMiddleware, handlers.GetWishlist)
+	r.POST("/api/wishlist", handlers.AuthMiddleware, handlers.AddToWishlist)
+	r.DELETE("/api/wishlist/:product_id", handlers.AuthMiddleware, handlers.RemoveFromWishlist)
+	r.POST("/api/wishlist/:product_id/move_to_cart", handlers.AuthMiddleware, handlers.MoveWishlistToCart)
 	
+	r.GET("/api/addresses", handlers.AuthMiddleware, handlers.GetAddresses)
+	r.POST("/api/addresses", handlers.AuthMiddleware, handlers.CreateAddress)
+	r.PUT("/api/addresses/:id", handlers.AuthMiddleware, handlers.UpdateAddress)
+	r.DELETE("/api/addresses/:id", handlers.AuthMiddleware, handlers.DeleteAddress)
+
 	r.POST("/api/order/create", handlers.AuthMiddleware, handlers.CreateOrder)
 	r.GET("/api/order/get_all", handlers.AuthMiddleware, handlers.GetUserOrders)
+	r.GET("/api/order/:order_number", handlers.AuthMiddleware, handlers.GetOrder)
+	r.POST("/api/order/:order_number/cancel", handlers.AuthMiddleware, handlers.CancelOrder)
+	r.POST("/api/order/:order_number/pay", handlers.AuthMiddleware, handlers.StartPayment)
+	r.POST("/api/order/:order_number/returns", handlers.AuthMiddleware, handlers.CreateReturn)
+
+	r.POST("/api/payments/webhook", handlers.PaymentWebhook)
+	// Имитация оплаты есть только у явно включённого fake-провайдера;
+	// оплатить можно только свой заказ
+	if _, ok := payments.Provider.(*payments.FakeProvider); ok {
+		r.POST("/api/payments/fake/:intent_id/authorize", handlers.AuthMiddleware, handlers.FakeAuthorizePayment)
+	}
+
+	r.GET("/api/image/get/:filename", handlers.GetProductImage)
+	r.HEAD("/api/image/get/:filename", handlers.GetProductImage)
 
-	r.GET("/api/image/get", handlers.AuthMiddleware, handlers.GetProductImage)
+	admin := r.Group("/api/admin", handlers.AuthMiddleware, handlers.RequireRole(models.RoleAdmin))
+	admin.PUT("/users/:id/role", handlers.UpdateUserRole)
+	admin.POST("/products/:id/restore", handlers.RestoreProduct)
+	admin.PUT("/orders/:order_number/status", handlers.UpdateOrderStatus)
+	admin.GET("/returns", handlers.GetReturns)
+	admin.PUT("/returns/:id", handlers.ResolveReturn)
+	admin.POST("/categories", handlers.CreateCategory)
+	admin.PUT("/categories/:id", handlers.UpdateCategory)
+	admin.DELETE("/categories/:id", handlers.DeleteCategory)
+	admin.POST("/categories/:id/attributes", handlers.CreateAttribute)
+	admin.PUT("/attributes/:id", handlers.UpdateAttribute)
+	admin.DELETE("/attributes/:id", handlers.DeleteAttribute)
+	admin.GET("/coupons", handlers.GetCoupons)
+	admin.POST("/coupons", handlers.CreateCoupon)
+	admin.PUT("/coupons/:id", handlers.UpdateCoupon)
+	admin.DELETE("/coupons/:id", handlers.DeleteCoupon)
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	r.Run()
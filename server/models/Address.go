@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrAddressIncomplete = errors.New("address is incomplete")
+
+// AddressFields — поля адреса доставки. Используются и в адресной книге,
+// и как снимок адреса в заказе.
+type AddressFields struct {
+	RecipientName string `json:"recipient_name" example:"Иван Иванов"`
+	Phone string `json:"phone" example:"+79991234567"`
+	Country string `json:"country" example:"Россия"`
+	City string `json:"city" example:"Москва"`
+	Street string `json:"street" example:"Верхняя Первомайская"`
+	Building string `json:"building" example:"52"`
+	Apartment string `json:"apartment,omitempty" example:"12"`
+	PostalCode string `json:"postal_code" example:"105264"`
+}
+
+// Address — сохранённый адрес доставки пользователя
+type Address struct {
+	gorm.Model `json:"-"`
+	ID uint `gorm:"primary key" json:"id"`
+	UserID uint `gorm:"index;uniqueIndex:idx_addresses_user_default,where:is_default AND deleted_at IS NULL;not null" json:"-"`
+	User User `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	AddressFields `gorm:"embedded"`
+	IsDefault bool `gorm:"not null;default:false" json:"is_default"`
+}
+
+// Validate проверяет, что заполнены все поля, нужные для доставки
+func (fields AddressFields) Validate() error {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"recipient_name", fields.RecipientName},
+		{"phone", fields.Phone},
+		{"country", fields.Country},
+		{"city", fields.City},
+		{"street", fields.Street},
+		{"building", fields.Building},
+		{"postal_code", fields.PostalCode},
+	}
+
+	var missing []string
+	for _, field := range required {
+		if strings.TrimSpace(field.value) == "" {
+			missing = append(missing, field.name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing %s", ErrAddressIncomplete, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// String собирает адрес в одну строку: "Россия, 105264, Москва, Верхняя Первомайская, 52, кв. 12"
+func (fields AddressFields) String() string {
+	parts := []string{fields.Country, fields.PostalCode, fields.City, fields.Street, fields.Building}
+	if fields.Apartment != "" {
+		parts = append(parts, "кв. "+fields.Apartment)
+	}
+
+	nonEmpty := parts[:0]
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+type RequestAddress struct {
+	RecipientName string `json:"recipient_name" binding:"required,max=255" example:"Иван Иванов"`
+	Phone string `json:"phone" binding:"required,min=5,max=20" example:"+79991234567"`
+	Country string `json:"country" binding:"required,max=100" example:"Россия"`
+	City string `json:"city" binding:"required,max=100" example:"Москва"`
+	Street string `json:"street" binding:"required,max=255" example:"Верхняя Первомайская"`
+	Building string `json:"building" binding:"required,max=50" example:"52"`
+	Apartment string `json:"apartment" binding:"max=50" example:"12"`
+	PostalCode string `json:"postal_code" binding:"required,max=20" example:"105264"`
+	IsDefault bool `json:"is_default" example:"true"`
+}
+
+// Fields возвращает поля адреса из запроса без лишних пробелов
+func (req RequestAddress) Fields() AddressFields {
+	return AddressFields{
+		RecipientName: strings.TrimSpace(req.RecipientName),
+		Phone: strings.TrimSpace(req.Phone),
+		Country: strings.TrimSpace(req.Country),
+		City: strings.TrimSpace(req.City),
+		Street: strings.TrimSpace(req.Street),
+		Building: strings.TrimSpace(req.Building),
+		Apartment: strings.TrimSpace(req.Apartment),
+		PostalCode: strings.TrimSpace(req.PostalCode),
+	}
+}
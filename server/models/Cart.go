@@ -1,6 +1,9 @@
 package models
 
 import (
+	"math"
+	"time"
+
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -9,11 +12,163 @@ type Cart struct {
 	gorm.Model `json:"-"`
 	ID uint `gorm:"primary key" json:"id"`
 	UserID     uint `gorm:"foreignKey" json:"user_id"`
+	// Уникален среди неудалённых корзин: после оформления или очистки гостевой
+	// корзины браузер присылает тот же токен, и под ним заводится новая
+	GuestToken *string `gorm:"uniqueIndex:idx_carts_guest_token,where:deleted_at IS NULL;size:64" json:"-"`
 	Items      []CartItem `gorm:"foreignKey:CartID;references:ID;constraint:OnDelete:CASCADE" json:"items"`
-	Total float64 `json:"total"`
+	CouponID *uint `json:"-"`
+	Coupon *Coupon `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	// Код купона, если его скидка действует на текущую корзину
+	CouponCode string `gorm:"-" json:"coupon_code,omitempty" example:"MEOW10"`
+	CartTotals `gorm:"-"`
+	Warnings []CartWarning `gorm:"-" json:"warnings"`
+}
+
+type CartWarningType string
+
+const (
+	CartWarningPriceChanged CartWarningType = "price_changed"
+	CartWarningUnavailable CartWarningType = "product_unavailable"
+	CartWarningInsufficientStock CartWarningType = "insufficient_stock"
+	CartWarningCouponNotApplied CartWarningType = "coupon_not_applied"
+)
+
+// CartWarning описывает расхождение позиции корзины с текущим состоянием товара
+type CartWarning struct {
+	Type CartWarningType `json:"type" example:"price_changed"`
+	ProductID uint `json:"product_id,omitempty" example:"1"`
+	VariantID uint `json:"variant_id,omitempty" example:"3"`
+	Message string `json:"message,omitempty"`
+	OldPrice float64 `json:"old_price,omitempty" example:"1500"`
+	NewPrice float64 `json:"new_price,omitempty" example:"1700"`
+	Quantity uint `json:"quantity,omitempty" example:"3"`
+	AvailableQuantity *uint `json:"available_quantity,omitempty" example:"2"`
+}
+
+// CartTotals считаются по позициям корзины при каждом чтении и не хранятся в базе.
+// Цены указаны с НДС, Tax — входящая в Total часть налога.
+type CartTotals struct {
+	Subtotal float64 `json:"subtotal" example:"3000"`
+	Discount float64 `json:"discount" example:"0"`
+	Shipping float64 `json:"shipping" example:"0"`
+	Tax float64 `json:"tax" example:"500"`
+	Total float64 `json:"total" example:"3000"`
 }
 
 func (cart *Cart) AfterDelete(tx *gorm.DB) (err error) {
 	tx.Clauses(clause.Returning{}).Where("cart_id = ?", cart.ID).Delete(&CartItem{})
 	return
-}
\ No newline at end of file
+}
+
+// Revalidate сверяет позиции с текущими вариантами товаров и собирает предупреждения:
+// удалённый товар, изменившаяся цена, нехватка на складе. Цена позиции
+// заменяется текущей ценой варианта только в памяти — в базе остаётся цена
+// на момент добавления, поэтому предупреждение держится до оформления заказа.
+// Product и Variant должны быть подгружены через WithDeletedProducts.
+func (cart *Cart) Revalidate() {
+	cart.Warnings = []CartWarning{}
+
+	for i := range cart.Items {
+		item := &cart.Items[i]
+
+		if !item.Available {
+			cart.Warnings = append(cart.Warnings, CartWarning{
+				Type: CartWarningUnavailable,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+			})
+			continue
+		}
+
+		if item.Price != item.Variant.Price {
+			cart.Warnings = append(cart.Warnings, CartWarning{
+				Type: CartWarningPriceChanged,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				OldPrice: item.Price,
+				NewPrice: item.Variant.Price,
+			})
+			item.Price = item.Variant.Price
+		}
+
+		if item.Quantity > item.Variant.Stock {
+			stock := item.Variant.Stock
+			cart.Warnings = append(cart.Warnings, CartWarning{
+				Type: CartWarningInsufficientStock,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity: item.Quantity,
+				AvailableQuantity: &stock,
+			})
+		}
+	}
+}
+
+// HasPriceChanges сообщает, нашёл ли Revalidate позиции с изменившейся ценой
+func (cart *Cart) HasPriceChanges() bool {
+	for _, warning := range cart.Warnings {
+		if warning.Type == CartWarningPriceChanged {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesTotal сравнивает сумму корзины с суммой, которую видел пользователь
+func (cart *Cart) MatchesTotal(total float64) bool {
+	return roundMoney(total) == cart.Total
+}
+
+// CalculateTotals пересчитывает суммы позиций и корзины. Недоступные позиции
+// в сумму не входят. Скидка купона (Coupon должен быть подгружен) распределяется
+// по позициям; если купон не действует, причина добавляется в Warnings.
+func (cart *Cart) CalculateTotals(taxRate float64, shippingCost float64) {
+	cart.CartTotals = CartTotals{}
+	cart.CouponCode = ""
+
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		item.LineTotal = roundMoney(item.Price * float64(item.Quantity))
+		item.Discount = 0
+
+		if item.Available {
+			cart.Subtotal += item.LineTotal
+		}
+	}
+
+	cart.Subtotal = roundMoney(cart.Subtotal)
+	if cart.Subtotal > 0 {
+		cart.Shipping = roundMoney(shippingCost)
+	}
+
+	if cart.Coupon != nil {
+		cart.applyCoupon()
+	}
+
+	cart.Total = roundMoney(math.Max(cart.Subtotal-cart.Discount, 0) + cart.Shipping)
+	cart.Tax = roundMoney(cart.Total * taxRate / (1 + taxRate))
+}
+
+func (cart *Cart) applyCoupon() {
+	err := cart.Coupon.Usable(time.Now())
+	if err == nil {
+		cart.Discount, err = cart.Coupon.applyDiscount(cart)
+	}
+
+	if err != nil {
+		cart.Warnings = append(cart.Warnings, CartWarning{
+			Type: CartWarningCouponNotApplied,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	cart.CouponCode = cart.Coupon.Code
+	if cart.Coupon.FreeShipping || cart.Coupon.Type == CouponTypeFreeShipping {
+		cart.Shipping = 0
+	}
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -8,17 +8,47 @@ type CartItem struct {
 	CartID uint `json:"cart_id"`
 	ProductID uint `json:"product_id"`
 	Product Product `gorm:"foreignKey:ProductID" json:"product"`
+	VariantID uint `gorm:"index" json:"variant_id"`
+	Variant ProductVariant `gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE" json:"variant"`
 	Quantity uint `json:"quantity"`
-	Price float64 `json:"-"`
+	Price float64 `json:"unit_price"`
+	LineTotal float64 `gorm:"-" json:"line_total"`
+	// Доля скидки купона, приходящаяся на позицию
+	Discount float64 `gorm:"-" json:"discount"`
+	Available bool `gorm:"-" json:"available"`
+}
+
+// AfterFind помечает позиции, чей товар или вариант удалён. Product и Variant
+// должны быть подгружены через WithDeletedProducts, иначе позиция считается недоступной.
+func (item *CartItem) AfterFind(tx *gorm.DB) (err error) {
+	item.Available = item.Product.ID != 0 && !item.Product.DeletedAt.Valid &&
+		item.Variant.ID != 0 && !item.Variant.DeletedAt.Valid
+	return
 }
 
 type RequestCartItem struct {
-	ProductID uint `json:"product_id"`
-	Quantity uint `json:"quantity"`
+	ProductID uint `json:"product_id" binding:"required"`
+	// Вариант товара; если не указан, берётся вариант по умолчанию
+	VariantID uint `json:"variant_id"`
+	Quantity uint `json:"quantity" binding:"required,min=1"`
 }
 
 type RequestRemoveCartItem struct {
 	ProductID uint `json:"product_id"`
+	VariantID uint `json:"variant_id"`
 }
 
+type RequestSetCartItem struct {
+	VariantID uint `json:"variant_id" example:"3"`
+	Quantity *uint `json:"quantity" binding:"required" example:"2"`
+}
+
+type RequestCartLine struct {
+	ProductID uint `json:"product_id" binding:"required" example:"1"`
+	VariantID uint `json:"variant_id" example:"3"`
+	Quantity *uint `json:"quantity" binding:"required" example:"2"`
+}
 
+type RequestBulkCartItems struct {
+	Items []RequestCartLine `json:"items" binding:"required,min=1,dive"`
+}
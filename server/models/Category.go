@@ -0,0 +1,46 @@
+package models
+
+import "gorm.io/gorm"
+
+// DefaultLanguage — язык названий категорий, если запрошенного перевода нет
+const DefaultLanguage = "ru"
+
+// Category — узел дерева категорий товаров. Names хранит названия по языкам:
+// {"ru": "Электроника", "en": "Electronics"}.
+type Category struct {
+	gorm.Model `json:"-"`
+	ID uint `gorm:"primary key" json:"id"`
+	Slug string `gorm:"uniqueIndex:idx_categories_slug,where:deleted_at IS NULL;not null" json:"slug" example:"electronics"`
+	Names map[string]string `gorm:"type:jsonb;serializer:json;not null" json:"names"`
+	ParentID *uint `gorm:"index" json:"parent_id"`
+	Parent *Category `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	SortOrder int `gorm:"not null;default:0" json:"sort_order" example:"0"`
+	Name string `gorm:"-" json:"name" example:"Электроника"`
+	ProductCount int64 `gorm:"-" json:"product_count" example:"12"`
+	Children []*Category `gorm:"-" json:"children"`
+}
+
+// LocalizedName возвращает название на языке lang, иначе на языке по умолчанию,
+// иначе slug
+func (category *Category) LocalizedName(lang string) string {
+	return localizedName(category.Names, lang, category.Slug)
+}
+
+// localizedName выбирает из names перевод на lang, затем на язык по умолчанию,
+// затем fallback
+func localizedName(names map[string]string, lang string, fallback string) string {
+	if name := names[lang]; name != "" {
+		return name
+	}
+	if name := names[DefaultLanguage]; name != "" {
+		return name
+	}
+	return fallback
+}
+
+type RequestCategory struct {
+	Slug string `json:"slug" binding:"required,max=100" example:"electronics"`
+	Names map[string]string `json:"names" binding:"required,min=1" example:"ru:Электроника,en:Electronics"`
+	ParentID *uint `json:"parent_id"`
+	SortOrder int `json:"sort_order" example:"0"`
+}
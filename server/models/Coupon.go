@@ -0,0 +1,181 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CouponType string
+
+const (
+	CouponTypePercentage CouponType = "percentage"
+	CouponTypeFixed CouponType = "fixed"
+	// Купон только на бесплатную доставку, без скидки на товары
+	CouponTypeFreeShipping CouponType = "free_shipping"
+)
+
+var (
+	ErrCouponInactive = errors.New("coupon is not active")
+	ErrCouponNotStarted = errors.New("coupon is not active yet")
+	ErrCouponExpired = errors.New("coupon has expired")
+	ErrCouponUsageLimit = errors.New("coupon usage limit reached")
+	ErrCouponUserLimit = errors.New("coupon has already been used the maximum number of times")
+	ErrCouponMinCartValue = errors.New("cart value is below the coupon minimum")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to any product in the cart")
+)
+
+// Coupon — промокод со скидкой. CategoryID и ProductID ограничивают товары,
+// на которые действует скидка; если оба пусты, скидка действует на всю корзину.
+// Категория купона включает все свои подкатегории.
+type Coupon struct {
+	gorm.Model `json:"-"`
+	ID uint `gorm:"primary key" json:"id"`
+	Code string `gorm:"uniqueIndex:idx_coupons_code,where:deleted_at IS NULL;not null" json:"code" example:"MEOW10"`
+	Type CouponType `gorm:"not null" json:"type" example:"percentage"`
+	Value float64 `json:"value" example:"10"`
+	MinCartValue float64 `json:"min_cart_value" example:"1000"`
+	CategoryID *uint `gorm:"index" json:"category_id,omitempty" example:"1"`
+	ProductID *uint `json:"product_id,omitempty"`
+	FreeShipping bool `json:"free_shipping" example:"false"`
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	UsageLimit *uint `json:"usage_limit,omitempty" example:"100"`
+	PerUserLimit *uint `json:"per_user_limit,omitempty" example:"1"`
+	UsedCount uint `gorm:"not null;default:0" json:"used_count" example:"0"`
+	Active bool `gorm:"not null" json:"active" example:"true"`
+	// id категории CategoryID и всех её подкатегорий, заполняется в AfterFind
+	categoryIDs map[uint]bool
+}
+
+// CouponRedemption фиксирует использование купона в заказе, по ним считается
+// лимит на пользователя
+type CouponRedemption struct {
+	gorm.Model `json:"-"`
+	CouponID uint `gorm:"index;not null"`
+	UserID uint `gorm:"index;not null"`
+	OrderID uint `gorm:"uniqueIndex;not null"`
+}
+
+// NormalizeCouponCode приводит код к виду, в котором он хранится в базе
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Usable проверяет, что купон активен, действует в момент now и не исчерпал общий лимит.
+// Лимит на пользователя проверяется отдельно по CouponRedemption.
+func (coupon *Coupon) Usable(now time.Time) error {
+	switch {
+	case !coupon.Active:
+		return ErrCouponInactive
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return ErrCouponNotStarted
+	case coupon.ExpiresAt != nil && !now.Before(*coupon.ExpiresAt):
+		return ErrCouponExpired
+	case coupon.UsageLimit != nil && coupon.UsedCount >= *coupon.UsageLimit:
+		return ErrCouponUsageLimit
+	}
+	return nil
+}
+
+// categorySubtreeByID — id категории и всех её подкатегорий
+const categorySubtreeByID = `WITH RECURSIVE tree AS (
+	SELECT id FROM categories WHERE id = ? AND deleted_at IS NULL
+	UNION
+	SELECT categories.id FROM categories JOIN tree ON categories.parent_id = tree.id WHERE categories.deleted_at IS NULL
+) SELECT id FROM tree`
+
+// AfterFind загружает поддерево категории купона
+func (coupon *Coupon) AfterFind(tx *gorm.DB) (err error) {
+	if coupon.CategoryID == nil {
+		return
+	}
+
+	var ids []uint
+	if err = tx.Session(&gorm.Session{NewDB: true}).Raw(categorySubtreeByID, *coupon.CategoryID).Scan(&ids).Error; err != nil {
+		return
+	}
+
+	coupon.categoryIDs = make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		coupon.categoryIDs[id] = true
+	}
+	return
+}
+
+// appliesTo сообщает, входит ли позиция в область действия купона
+func (coupon *Coupon) appliesTo(item CartItem) bool {
+	if coupon.ProductID != nil && *coupon.ProductID != item.ProductID {
+		return false
+	}
+	if coupon.CategoryID != nil && (item.Product.CategoryID == nil || !coupon.categoryIDs[*item.Product.CategoryID]) {
+		return false
+	}
+	return true
+}
+
+// applyDiscount распределяет скидку купона по подходящим позициям пропорционально
+// их сумме и возвращает общую скидку. Subtotal корзины должен быть уже посчитан.
+func (coupon *Coupon) applyDiscount(cart *Cart) (float64, error) {
+	if cart.Subtotal < coupon.MinCartValue {
+		return 0, ErrCouponMinCartValue
+	}
+
+	var eligible []*CartItem
+	var eligibleTotal float64
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		if item.Available && coupon.appliesTo(*item) {
+			eligible = append(eligible, item)
+			eligibleTotal += item.LineTotal
+		}
+	}
+
+	if len(eligible) == 0 || eligibleTotal == 0 {
+		return 0, ErrCouponNotApplicable
+	}
+
+	var discount float64
+	switch coupon.Type {
+	case CouponTypePercentage:
+		discount = eligibleTotal * math.Min(coupon.Value, 100) / 100
+	case CouponTypeFixed:
+		discount = math.Min(coupon.Value, eligibleTotal)
+	}
+	discount = roundMoney(discount)
+
+	// Последней позиции достаётся остаток, чтобы сумма долей совпала со скидкой
+	remaining := discount
+	for i, item := range eligible {
+		if i == len(eligible)-1 {
+			item.Discount = roundMoney(remaining)
+			break
+		}
+		item.Discount = roundMoney(discount * item.LineTotal / eligibleTotal)
+		remaining -= item.Discount
+	}
+
+	return discount, nil
+}
+
+type RequestApplyCoupon struct {
+	Code string `json:"code" binding:"required" example:"MEOW10"`
+}
+
+type RequestCoupon struct {
+	Code string `json:"code" binding:"required" example:"MEOW10"`
+	Type CouponType `json:"type" binding:"required,oneof=percentage fixed free_shipping" example:"percentage"`
+	Value float64 `json:"value" binding:"min=0" example:"10"`
+	MinCartValue float64 `json:"min_cart_value" binding:"min=0" example:"1000"`
+	Category string `json:"category" example:"electronics"` // slug категории, скидка действует и на подкатегории
+	ProductID *uint `json:"product_id"`
+	FreeShipping bool `json:"free_shipping" example:"false"`
+	StartsAt *time.Time `json:"starts_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	UsageLimit *uint `json:"usage_limit" example:"100"`
+	PerUserLimit *uint `json:"per_user_limit" example:"1"`
+	Active *bool `json:"active" example:"true"`
+}
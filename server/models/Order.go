@@ -9,39 +9,116 @@ import (
 
 type Order struct {
 	gorm.Model `swaggerignore:"true"`
-	UserID uint `json:"user_id"`
-	User User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	UserID uint `gorm:"uniqueIndex:idx_orders_user_idempotency_key,priority:1" json:"user_id"`
+	User User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+	IdempotencyKey *string `gorm:"size:255;uniqueIndex:idx_orders_user_idempotency_key,priority:2" json:"-"`
 	Total float64 `json:"total" example:"53.999"`
-	Address string `json:"address" example:"Россия, Москва, Верхняя Первомайская, 52"`
-	Items []OrderItem `json:"foreignKey:OrderID"`
-	Status string `json:"status" example:"created"`
-	OrderNumber string `json:"order_number" example:"ORD-2025-1010"`
+	Discount float64 `json:"discount" example:"0"`
+	Shipping float64 `json:"shipping" example:"0"`
+	CouponCode string `json:"coupon_code,omitempty" example:"MEOW10"`
+	Address string `json:"address" example:"Россия, 105264, Москва, Верхняя Первомайская, 52"`
+	// Снимок адреса из адресной книги на момент оформления. У старых заказов пуст
+	ShippingAddress AddressFields `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
+	Items []OrderItem `gorm:"foreignKey:OrderID" json:"items"`
+	Status OrderStatus `gorm:"not null;default:created" json:"status" example:"created"`
+	StatusHistory []OrderStatusEvent `gorm:"foreignKey:OrderID" json:"status_history,omitempty"`
+	Payments []Payment `gorm:"foreignKey:OrderID" json:"payments,omitempty"`
+	Returns []ReturnRequest `gorm:"foreignKey:OrderID" json:"returns,omitempty"`
+	RefundedTotal float64 `json:"refunded_total" example:"0"`
+	OrderNumber string `gorm:"uniqueIndex;not null" json:"order_number" example:"ORD-2025-1010"`
 	Date time.Time `gorm:"type:timestamp;default:CURRENT_TIMESTAMP" json:"date"`
 }
 
+// OrderItem хранит снимок товара на момент оформления, чтобы последующие
+// изменения цены или удаление товара не переписывали историю заказов
 type OrderItem struct {
 	gorm.Model `swaggerignore:"true"`
-	OrderID uint `json:"cart_id"`
-	Product Product `gorm:"foreignKey:ProductID;constraint:OnDelete:Cascade"`
+	OrderID uint `json:"order_id"`
+	Product Product `gorm:"foreignKey:ProductID;constraint:OnDelete:SET NULL" json:"product"`
 	ProductID uint `json:"product_id"`
+	Variant ProductVariant `gorm:"foreignKey:VariantID;constraint:OnDelete:SET NULL" json:"-"`
+	VariantID uint `json:"variant_id"`
 	Quantity uint `json:"quantity"`
+	Title string `json:"title" example:"Когтеточка"`
+	Image string `json:"image" example:"/assets/cat-surprised.gif"`
+	SKU string `gorm:"column:sku" json:"sku" example:"CAT-TREE-L-GREY"`
+	Attributes map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"attributes,omitempty"`
+	UnitPrice float64 `json:"unit_price" example:"1500"`
+	LineTotal float64 `json:"line_total" example:"3000"`
+	// Доля скидки купона, приходящаяся на позицию; учитывается при возврате
+	Discount float64 `json:"discount" example:"0"`
+	ReturnedQuantity uint `json:"returned_quantity" example:"0"`
+	Available bool `gorm:"-" json:"available"`
+}
+
+// NewOrderItem снимает с позиции корзины текущие название, картинку, артикул,
+// характеристики и цену варианта. Product и Variant у позиции должны быть подгружены.
+func NewOrderItem(item CartItem) OrderItem {
+	image := item.Variant.Image
+	if image == "" {
+		image = item.Product.Image
+	}
+
+	return OrderItem{
+		ProductID: item.ProductID,
+		VariantID: item.VariantID,
+		Quantity: item.Quantity,
+		Title: item.Product.Title,
+		Image: image,
+		SKU: item.Variant.SKU,
+		Attributes: item.Variant.Attributes,
+		UnitPrice: item.Variant.Price,
+		LineTotal: item.Variant.Price * float64(item.Quantity),
+		Discount: item.Discount,
+	}
+}
+
+// RecalculateTotal пересчитывает скидку и сумму заказа по его позициям и доставке
+func (order *Order) RecalculateTotal() {
+	order.Total = 0
+	order.Discount = 0
+	for _, item := range order.Items {
+		order.Total += item.LineTotal - item.Discount
+		order.Discount += item.Discount
+	}
+	order.Discount = roundMoney(order.Discount)
+	order.Total = roundMoney(order.Total + order.Shipping)
+}
+
+// RefundFor возвращает сумму к возврату за quantity единиц позиции с учётом скидки
+func (item *OrderItem) RefundFor(quantity uint) float64 {
+	if item.Quantity == 0 {
+		return 0
+	}
+	return roundMoney((item.LineTotal - item.Discount) * float64(quantity) / float64(item.Quantity))
+}
+
+// AfterFind помечает позиции заказа, чей товар удалён.
+func (item *OrderItem) AfterFind(tx *gorm.DB) (err error) {
+	item.Available = item.Product.ID != 0 && !item.Product.DeletedAt.Valid
+	return
+}
+
+// OrderNumberCounter хранит последний выданный номер заказа за год
+type OrderNumberCounter struct {
+	Year int `gorm:"primaryKey;autoIncrement:false"`
+	Value uint `gorm:"not null"`
 }
 
 func (order *Order) BeforeCreate(tx *gorm.DB) error {
 	if order.OrderNumber == "" {
-			// Формат: ORD-ГОД-ПОСЛЕДНИЙ_ID+1
-			var lastOrder Order
-			var lastOrderID uint
-			if err := tx.Order("id desc").First(&lastOrder).Error; err != nil {
-				lastOrderID = 0
-			} else {
-				lastOrderID = lastOrder.ID
-			}
-
+			// Формат: ORD-ГОД-НОМЕР_ЗА_ГОД. Счётчик увеличивается одним запросом,
+			// строка года блокируется до конца транзакции, поэтому номера не повторяются
 			year := time.Now().Year()
-			newID := int(lastOrderID) + 1
+			var number uint
 
-			order.OrderNumber = fmt.Sprintf("ORD-%d-%04d", year, newID)
+			if err := tx.Raw(`INSERT INTO order_number_counters (year, value) VALUES (?, 1)
+				ON CONFLICT (year) DO UPDATE SET value = order_number_counters.value + 1
+				RETURNING value`, year).Scan(&number).Error; err != nil {
+				return err
+			}
+
+			order.OrderNumber = fmt.Sprintf("ORD-%d-%04d", year, number)
 	}
 	return nil
-}
\ No newline at end of file
+}
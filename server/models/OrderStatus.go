@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OrderStatus string
+
+const (
+	OrderStatusCreated   OrderStatus = "created"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusPacked    OrderStatus = "packed"
+	OrderStatusShipped   OrderStatus = "shipped"
+	OrderStatusDelivered OrderStatus = "delivered"
+	OrderStatusCancelled OrderStatus = "cancelled"
+	OrderStatusRefunded  OrderStatus = "refunded"
+)
+
+var ErrInvalidStatusTransition = errors.New("invalid order status transition")
+
+// orderTransitions перечисляет допустимые переходы между статусами заказа
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusCreated:   {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusPacked, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusPacked:    {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:   {OrderStatusDelivered},
+	OrderStatusDelivered: {OrderStatusRefunded},
+}
+
+// OrderStatusEvent — запись истории смены статуса заказа
+type OrderStatusEvent struct {
+	ID uint `gorm:"primary key" json:"-"`
+	OrderID uint `gorm:"index;not null" json:"-"`
+	FromStatus OrderStatus `json:"from_status" example:"created"`
+	ToStatus OrderStatus `gorm:"not null" json:"to_status" example:"paid"`
+	ChangedByID uint `json:"changed_by_id"`
+	Comment string `json:"comment" example:"Оплачен картой"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RequestOrderStatus struct {
+	Status OrderStatus `json:"status" binding:"required,oneof=created paid packed shipped delivered cancelled refunded" example:"packed"`
+	Comment string `json:"comment" example:"Передан в сборку"`
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// CancellableByCustomer сообщает, может ли покупатель сам отменить заказ в этом статусе
+func (s OrderStatus) CancellableByCustomer() bool {
+	return s == OrderStatusCreated || s == OrderStatusPaid
+}
+
+// ReleasesStock сообщает, возвращаются ли товары на склад при переходе в next:
+// при отмене и при возврате денег за ещё не отправленный заказ
+func (s OrderStatus) ReleasesStock(next OrderStatus) bool {
+	return next == OrderStatusCancelled || (next == OrderStatusRefunded && (s == OrderStatusPaid || s == OrderStatusPacked))
+}
+
+// RequiresRefund сообщает, нужно ли вернуть оплату при переходе в next
+func (s OrderStatus) RequiresRefund(next OrderStatus) bool {
+	return next == OrderStatusRefunded || (next == OrderStatusCancelled && s != OrderStatusCreated)
+}
+
+// Transition переводит заказ в статус to и записывает событие в историю.
+// Статус меняется только если в базе он всё ещё равен order.Status.
+func (order *Order) Transition(tx *gorm.DB, to OrderStatus, changedByID uint, comment string) error {
+	from := order.Status
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, from, to)
+	}
+
+	result := tx.Model(&Order{}).Where("id = ? AND status = ?", order.ID, from).Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: status of order %s has changed", ErrInvalidStatusTransition, order.OrderNumber)
+	}
+
+	event := OrderStatusEvent{
+		OrderID: order.ID,
+		FromStatus: from,
+		ToStatus: to,
+		ChangedByID: changedByID,
+		Comment: comment,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return err
+	}
+
+	order.Status = to
+	return nil
+}
+
+// AfterCreate записывает в историю начальный статус заказа
+func (order *Order) AfterCreate(tx *gorm.DB) error {
+	return tx.Create(&OrderStatusEvent{
+		OrderID: order.ID,
+		ToStatus: order.Status,
+		ChangedByID: order.UserID,
+	}).Error
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentStatusPending  PaymentStatus = "pending"
+	PaymentStatusPaid     PaymentStatus = "paid"
+	PaymentStatusFailed   PaymentStatus = "failed"
+	PaymentStatusRefunded PaymentStatus = "refunded"
+)
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusSucceeded RefundStatus = "succeeded"
+)
+
+// Payment связывает заказ с платежом у провайдера
+type Payment struct {
+	gorm.Model `json:"-"`
+	OrderID uint `gorm:"index;not null" json:"-"`
+	Provider string `gorm:"not null" json:"provider" example:"fake"`
+	IntentID string `gorm:"uniqueIndex;not null" json:"intent_id"`
+	Amount float64 `json:"amount" example:"3000"`
+	RefundedAmount float64 `json:"refunded_amount" example:"0"`
+	Status PaymentStatus `gorm:"not null;default:pending" json:"status" example:"pending"`
+}
+
+// RefundableAmount — сколько ещё можно вернуть по платежу
+func (payment *Payment) RefundableAmount() float64 {
+	if payment.Status != PaymentStatusPaid {
+		return 0
+	}
+	return payment.Amount - payment.RefundedAmount
+}
+
+// PaymentRefund — возврат по платежу. Он сохраняется в одной транзакции со
+// сменой статуса заказа, а провайдеру отправляется уже после её фиксации:
+// откат транзакции не может оставить деньги возвращёнными без записи об этом
+type PaymentRefund struct {
+	ID uint `gorm:"primary key" json:"id"`
+	PaymentID uint `gorm:"index;not null" json:"-"`
+	Amount float64 `gorm:"not null" json:"amount" example:"1500"`
+	Status RefundStatus `gorm:"index;not null;default:pending" json:"status" example:"pending"`
+	ProviderRefundID string `json:"provider_refund_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// IdempotencyKey — ключ, с которым возврат отправляется провайдеру.
+// Повторная отправка после сбоя не вернёт деньги дважды
+func (refund *PaymentRefund) IdempotencyKey() string {
+	return fmt.Sprintf("refund-%d", refund.ID)
+}
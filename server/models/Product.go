@@ -6,8 +6,41 @@ type Product struct {
 	gorm.Model `json:"-"`
 	ID uint `gorm:"primary key" json:"id"`
 	Title string `gorm:"required" json:"title" example:"MacBook Pro"`
+	// Минимальная цена среди вариантов, пересчитывается при их изменении
 	Price float64 `gorm:"required" json:"price" example:"1500000"`
 	Description string `json:"description" example:"15.6 дюймов" `
 	Image string `json:"image" example:"/assets/cat-surprised.gif"`
+	// Slug категории. Дублирует categories.slug по CategoryID, чтобы категория
+	// попадала в полнотекстовый индекс товаров
 	Category string `gorm:"required" json:"category" example:"electronics"`
+	CategoryID *uint `gorm:"index" json:"category_id" example:"1"`
+	// Суммарный остаток всех вариантов, пересчитывается при их изменении
+	Stock uint `gorm:"not null;default:0" json:"stock" example:"10"`
+	Variants []ProductVariant `gorm:"foreignKey:ProductID" json:"variants,omitempty" swaggerignore:"true"`
+	// Галерея в порядке Position. Image — обложка, по умолчанию первая загруженная картинка
+	Images []ProductImage `gorm:"foreignKey:ProductID" json:"images,omitempty" swaggerignore:"true"`
+}
+
+type RequestProduct struct {
+	Title string `json:"title" binding:"required" example:"MacBook Pro"`
+	Price float64 `json:"price" binding:"required,gt=0" example:"1500000"`
+	Description string `json:"description" example:"15.6 дюймов"`
+	Image string `json:"image" example:"/assets/cat-surprised.gif"`
+	Category string `json:"category" binding:"required" example:"electronics"` // slug категории
+	Stock uint `json:"stock" example:"10"`
+}
+
+type RequestPatchProduct struct {
+	Title *string `json:"title" binding:"omitempty,min=1" example:"MacBook Pro"`
+	Price *float64 `json:"price" binding:"omitempty,gt=0" example:"1500000"`
+	Description *string `json:"description" example:"15.6 дюймов"`
+	Image *string `json:"image" example:"/assets/cat-surprised.gif"`
+	Category *string `json:"category" binding:"omitempty,min=1" example:"electronics"` // slug категории
+	Stock *uint `json:"stock" example:"10"`
+}
+
+// WithDeletedProducts подгружает товары или их варианты вместе с мягко удалёнными,
+// чтобы корзины и заказы не теряли ссылки на снятые с продажи позиции.
+func WithDeletedProducts(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
 }
\ No newline at end of file
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImagePath — адрес, по которому отдаются загруженные картинки: ImagePath + ключ
+const ImagePath = "/api/image/get/"
+
+// ProductImage — картинка из галереи товара. Сам файл лежит в хранилище
+// под ключом Key, порядок в галерее задаёт Position. Закрытые (Private)
+// картинки не показываются в каталоге и отдаются только по подписанной ссылке.
+type ProductImage struct {
+	ID uint `gorm:"primary key" json:"id"`
+	ProductID uint `gorm:"index;not null" json:"product_id"`
+	Product Product `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Key string `gorm:"uniqueIndex;not null" json:"key" example:"3f2a9c1e7b4d8a60.png"`
+	ContentType string `gorm:"not null" json:"content_type" example:"image/png"`
+	Size int64 `gorm:"not null" json:"size" example:"48213"`
+	Position int `gorm:"not null;default:0" json:"position"`
+	Private bool `gorm:"not null;default:false" json:"private"`
+	URL string `gorm:"-" json:"url" example:"/api/image/get/3f2a9c1e7b4d8a60.png"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AfterFind заполняет адрес картинки
+func (image *ProductImage) AfterFind(tx *gorm.DB) (err error) {
+	image.URL = ImagePath + image.Key
+	return
+}
+
+type RequestReorderImages struct {
+	// Все картинки товара в новом порядке
+	ImageIDs []uint `json:"image_ids" binding:"required,min=1" example:"3,1,2"`
+}
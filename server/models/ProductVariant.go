@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+type AttributeType string
+
+const (
+	AttributeTypeString AttributeType = "string"
+	AttributeTypeNumber AttributeType = "number"
+	AttributeTypeBoolean AttributeType = "boolean"
+	// Значение из списка Options
+	AttributeTypeEnum AttributeType = "enum"
+)
+
+var ErrInvalidAttributes = errors.New("invalid variant attributes")
+
+// AttributeDefinition описывает характеристику вариантов товаров категории
+// (размер, цвет, порода). Подкатегории наследуют характеристики родителей.
+type AttributeDefinition struct {
+	gorm.Model `json:"-"`
+	ID uint `gorm:"primary key" json:"id"`
+	CategoryID uint `gorm:"uniqueIndex:idx_attribute_definitions_category_code,priority:1,where:deleted_at IS NULL;not null" json:"category_id"`
+	Category Category `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Code string `gorm:"uniqueIndex:idx_attribute_definitions_category_code,priority:2;not null" json:"code" example:"size"`
+	Names map[string]string `gorm:"type:jsonb;serializer:json;not null" json:"names"`
+	Type AttributeType `gorm:"not null" json:"type" example:"enum"`
+	Options []string `gorm:"type:jsonb;serializer:json" json:"options,omitempty" example:"S,M,L"`
+	Required bool `gorm:"not null;default:false" json:"required"`
+	SortOrder int `gorm:"not null;default:0" json:"sort_order"`
+	Name string `gorm:"-" json:"name" example:"Размер"`
+}
+
+// ProductVariant — конкретный вариант товара со своими артикулом, ценой,
+// остатком и значениями характеристик. У каждого товара ровно один вариант
+// по умолчанию: он используется, когда клиент не указал variant_id.
+type ProductVariant struct {
+	gorm.Model `json:"-"`
+	ID uint `gorm:"primary key" json:"id"`
+	ProductID uint `gorm:"index;uniqueIndex:idx_product_variants_default,where:is_default AND deleted_at IS NULL;not null" json:"product_id"`
+	SKU string `gorm:"column:sku;uniqueIndex:idx_product_variants_sku,where:deleted_at IS NULL;not null" json:"sku" example:"CAT-TREE-L-GREY"`
+	Price float64 `gorm:"not null" json:"price" example:"1500"`
+	Stock uint `gorm:"not null;default:0" json:"stock" example:"10"`
+	Image string `json:"image,omitempty" example:"/assets/cat-surprised.gif"`
+	Attributes map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"attributes"`
+	IsDefault bool `gorm:"not null;default:false" json:"is_default"`
+}
+
+// LocalizedName возвращает название характеристики на языке lang
+func (definition *AttributeDefinition) LocalizedName(lang string) string {
+	return localizedName(definition.Names, lang, definition.Code)
+}
+
+// ValidateValue проверяет, что значение подходит под тип характеристики.
+// Числа приходят из JSON как float64.
+func (definition *AttributeDefinition) ValidateValue(value interface{}) error {
+	valid := false
+
+	switch definition.Type {
+	case AttributeTypeString:
+		text, ok := value.(string)
+		valid = ok && text != ""
+	case AttributeTypeNumber:
+		_, valid = value.(float64)
+	case AttributeTypeBoolean:
+		_, valid = value.(bool)
+	case AttributeTypeEnum:
+		text, ok := value.(string)
+		valid = ok && slices.Contains(definition.Options, text)
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: %s must be a valid %s", ErrInvalidAttributes, definition.Code, definition.Type)
+	}
+	return nil
+}
+
+// ValidateAttributes сверяет значения характеристик варианта с определениями категории:
+// неизвестные характеристики запрещены, обязательные должны быть заполнены.
+func ValidateAttributes(definitions []AttributeDefinition, values map[string]interface{}) error {
+	known := make(map[string]bool, len(definitions))
+
+	for i := range definitions {
+		definition := &definitions[i]
+		known[definition.Code] = true
+
+		value, ok := values[definition.Code]
+		if !ok || value == nil {
+			if definition.Required {
+				return fmt.Errorf("%w: %s is required", ErrInvalidAttributes, definition.Code)
+			}
+			continue
+		}
+
+		if err := definition.ValidateValue(value); err != nil {
+			return err
+		}
+	}
+
+	for code := range values {
+		if !known[code] {
+			return fmt.Errorf("%w: unknown attribute %s", ErrInvalidAttributes, code)
+		}
+	}
+
+	return nil
+}
+
+type RequestAttributeDefinition struct {
+	Code string `json:"code" binding:"required,max=50" example:"size"`
+	Names map[string]string `json:"names" binding:"required,min=1"`
+	Type AttributeType `json:"type" binding:"required,oneof=string number boolean enum" example:"enum"`
+	Options []string `json:"options" example:"S,M,L"`
+	Required bool `json:"required"`
+	SortOrder int `json:"sort_order"`
+}
+
+type RequestVariant struct {
+	SKU string `json:"sku" binding:"required,max=100" example:"CAT-TREE-L-GREY"`
+	Price float64 `json:"price" binding:"required,gt=0" example:"1500"`
+	Stock uint `json:"stock" example:"10"`
+	Image string `json:"image" example:"/assets/cat-surprised.gif"`
+	Attributes map[string]interface{} `json:"attributes"`
+	IsDefault bool `json:"is_default"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "gorm.io/gorm"
+
+type ReturnStatus string
+
+const (
+	ReturnStatusRequested ReturnStatus = "requested"
+	ReturnStatusApproved  ReturnStatus = "approved"
+	ReturnStatusRejected  ReturnStatus = "rejected"
+)
+
+// ReturnRequest — заявка покупателя на возврат части позиции заказа
+type ReturnRequest struct {
+	gorm.Model `json:"-"`
+	ID uint `gorm:"primary key" json:"id"`
+	OrderID uint `gorm:"index;not null" json:"-"`
+	OrderItemID uint `gorm:"index;not null" json:"order_item_id"`
+	OrderItem OrderItem `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID uint `gorm:"index;not null" json:"-"`
+	Quantity uint `gorm:"not null" json:"quantity" example:"1"`
+	Reason string `gorm:"not null" json:"reason" example:"Кот не оценил"`
+	Status ReturnStatus `gorm:"not null;default:requested" json:"status" example:"requested"`
+	RefundAmount float64 `json:"refund_amount" example:"1500"`
+	AdminComment string `json:"admin_comment"`
+	ResolvedByID uint `json:"-"`
+}
+
+type RequestReturnItem struct {
+	OrderItemID uint `json:"order_item_id" binding:"required"`
+	Quantity uint `json:"quantity" binding:"required,min=1" example:"1"`
+	Reason string `json:"reason" binding:"required" example:"Кот не оценил"`
+}
+
+type RequestCreateReturn struct {
+	Items []RequestReturnItem `json:"items" binding:"required,min=1,dive"`
+}
+
+type RequestResolveReturn struct {
+	Status ReturnStatus `json:"status" binding:"required,oneof=approved rejected" example:"approved"`
+	Comment string `json:"comment" example:"Возврат одобрен"`
+}
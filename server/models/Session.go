@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Session struct {
+	gorm.Model `json:"-"`
+	UserID uint `gorm:"index;not null" json:"user_id"`
+	User User `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	RefreshTokenHash string `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type RequestRefreshToken struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
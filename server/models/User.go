@@ -2,6 +2,12 @@ package models
 
 import "gorm.io/gorm"
 
+const (
+	RoleCustomer = "customer"
+	RoleManager  = "manager"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
 	gorm.Model `json:"-"`
 	Password string `json:"password" example:"12345678"`
@@ -9,4 +15,9 @@ type User struct {
 	FirstName string `json:"first_name"`
 	LastName string `json:"last_name"`
 	PhoneNumber string `json:"phone_number"`
+	Role string `gorm:"not null;default:customer" json:"role" example:"customer" swaggerignore:"true"`
+}
+
+type RequestUpdateRole struct {
+	Role string `json:"role" binding:"required,oneof=customer manager admin" example:"manager"`
 }
\ No newline at end of file
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Wishlist — товар, отложенный пользователем в избранное
+type Wishlist struct {
+	ID uint `gorm:"primary key" json:"id"`
+	UserID uint `gorm:"uniqueIndex:idx_wishlists_user_product,priority:1;not null" json:"-"`
+	User User `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ProductID uint `gorm:"uniqueIndex:idx_wishlists_user_product,priority:2;not null" json:"product_id"`
+	Product Product `gorm:"constraint:OnDelete:CASCADE" json:"product"`
+	Available bool `gorm:"-" json:"available"`
+	CreatedAt time.Time `json:"added_at"`
+}
+
+// AfterFind помечает избранные товары, снятые с продажи. Product должен быть
+// подгружен через WithDeletedProducts.
+func (item *Wishlist) AfterFind(tx *gorm.DB) (err error) {
+	item.Available = item.Product.ID != 0 && !item.Product.DeletedAt.Valid
+	return
+}
+
+type RequestWishlistItem struct {
+	ProductID uint `json:"product_id" binding:"required" example:"1"`
+}
+
+type RequestMoveToCart struct {
+	Quantity uint `json:"quantity" binding:"omitempty,min=1" example:"1"`
+	VariantID uint `json:"variant_id" example:"3"`
+}
@@ -0,0 +1,220 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	fakeIntentCreated    = "requires_payment"
+	fakeIntentAuthorized = "authorized"
+	fakeIntentCaptured   = "captured"
+)
+
+// fakeIntent хранится в базе, чтобы списание и возврат работали и после
+// перезапуска сервера
+type fakeIntent struct {
+	ID           string  `gorm:"primaryKey;size:64"`
+	ClientSecret string  `gorm:"not null"`
+	Amount       float64 `gorm:"not null"`
+	Status       string  `gorm:"not null"`
+	Refunded     float64 `gorm:"not null;default:0"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (fakeIntent) TableName() string {
+	return "fake_payment_intents"
+}
+
+// fakeRefund запоминает возврат по ключу идемпотентности
+type fakeRefund struct {
+	IdempotencyKey string  `gorm:"primaryKey;size:128"`
+	ID             string  `gorm:"not null"`
+	IntentID       string  `gorm:"index;not null"`
+	Amount         float64 `gorm:"not null"`
+	CreatedAt      time.Time
+}
+
+func (fakeRefund) TableName() string {
+	return "fake_payment_refunds"
+}
+
+func (intent fakeIntent) public() Intent {
+	return Intent{ID: intent.ID, ClientSecret: intent.ClientSecret, Amount: intent.Amount}
+}
+
+// FakeProvider — платёжный провайдер для локальной разработки и тестов.
+// Платежи хранятся в таблице fake_payment_intents, вебхуки подписываются
+// HMAC-SHA256 так же, как это делают настоящие провайдеры.
+type FakeProvider struct {
+	db     *gorm.DB
+	secret []byte
+}
+
+func NewFakeProvider(db *gorm.DB, webhookSecret string) (*FakeProvider, error) {
+	if err := db.AutoMigrate(&fakeIntent{}, &fakeRefund{}); err != nil {
+		return nil, err
+	}
+
+	return &FakeProvider{
+		db:     db,
+		secret: []byte(webhookSecret),
+	}, nil
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) CreateIntent(ctx context.Context, orderNumber string, amount float64) (Intent, error) {
+	id := "fake_pi_" + randomHex(12)
+	intent := fakeIntent{
+		ID:           id,
+		ClientSecret: id + "_secret_" + randomHex(12),
+		Amount:       amount,
+		Status:       fakeIntentCreated,
+	}
+
+	if err := p.db.WithContext(ctx).Create(&intent).Error; err != nil {
+		return Intent{}, err
+	}
+	return intent.public(), nil
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, intentID string) error {
+	return p.update(ctx, intentID, func(tx *gorm.DB, intent *fakeIntent) error {
+		if intent.Status == fakeIntentCaptured {
+			return nil
+		}
+		if intent.Status != fakeIntentAuthorized {
+			return fmt.Errorf("%w: %s", ErrInvalidState, intent.Status)
+		}
+
+		intent.Status = fakeIntentCaptured
+		return nil
+	})
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, intentID string, idempotencyKey string, amount float64) (string, error) {
+	refund := fakeRefund{
+		IdempotencyKey: idempotencyKey,
+		ID:             "fake_re_" + randomHex(12),
+		IntentID:       intentID,
+		Amount:         amount,
+	}
+
+	err := p.update(ctx, intentID, func(tx *gorm.DB, intent *fakeIntent) error {
+		var existing fakeRefund
+		err := tx.Where("idempotency_key = ?", idempotencyKey).First(&existing).Error
+		if err == nil {
+			if existing.IntentID != intentID || existing.Amount != amount {
+				return fmt.Errorf("%w: refund key %s was used for another refund", ErrInvalidState, idempotencyKey)
+			}
+			refund = existing
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if intent.Status != fakeIntentCaptured {
+			return fmt.Errorf("%w: %s", ErrInvalidState, intent.Status)
+		}
+		if amount <= 0 || intent.Refunded+amount > intent.Amount {
+			return fmt.Errorf("%w: refund of %.2f exceeds captured amount", ErrInvalidState, amount)
+		}
+
+		intent.Refunded += amount
+		return tx.Create(&refund).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return refund.ID, nil
+}
+
+// update блокирует платёж до конца транзакции, меняет его функцией change
+// и сохраняет
+func (p *FakeProvider) update(ctx context.Context, intentID string, change func(tx *gorm.DB, intent *fakeIntent) error) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var intent fakeIntent
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", intentID).First(&intent).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrIntentNotFound
+			}
+			return err
+		}
+
+		if err := change(tx, &intent); err != nil {
+			return err
+		}
+		return tx.Save(&intent).Error
+	})
+}
+
+func (p *FakeProvider) VerifyWebhook(payload []byte, signature string) (WebhookEvent, error) {
+	var event WebhookEvent
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, p.sign(payload)) {
+		return event, ErrInvalidSignature
+	}
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// Authorize имитирует оплату покупателем и возвращает подписанный вебхук
+// payment.authorized, который настоящий провайдер прислал бы серверу
+func (p *FakeProvider) Authorize(ctx context.Context, intentID string) ([]byte, string, error) {
+	var amount float64
+	err := p.update(ctx, intentID, func(tx *gorm.DB, intent *fakeIntent) error {
+		if intent.Status != fakeIntentCreated {
+			return fmt.Errorf("%w: %s", ErrInvalidState, intent.Status)
+		}
+
+		intent.Status = fakeIntentAuthorized
+		amount = intent.Amount
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	payload, err := json.Marshal(WebhookEvent{
+		ID:       "fake_evt_" + randomHex(12),
+		Type:     EventPaymentAuthorized,
+		IntentID: intentID,
+		Amount:   amount,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return payload, hex.EncodeToString(p.sign(payload)), nil
+}
+
+func (p *FakeProvider) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,127 @@
+package payments
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestFakeVerifyWebhook(t *testing.T) {
+	provider := &FakeProvider{secret: []byte("test-webhook-secret")}
+	payload := []byte(`{"id":"evt_1","type":"payment.authorized","intent_id":"pi_1","amount":100}`)
+	signature := hexSignature(provider, payload)
+
+	event, err := provider.VerifyWebhook(payload, signature)
+	if err != nil {
+		t.Fatalf("VerifyWebhook: %v", err)
+	}
+	if event.Type != EventPaymentAuthorized || event.IntentID != "pi_1" || event.Amount != 100 {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	for name, tc := range map[string]struct {
+		payload   []byte
+		signature string
+	}{
+		"tampered payload": {[]byte(`{"id":"evt_1","type":"payment.authorized","intent_id":"pi_1","amount":1}`), signature},
+		"not hex":          {payload, "not-a-signature"},
+		"empty":            {payload, ""},
+		"other secret":     {payload, hexSignature(&FakeProvider{secret: []byte("other")}, payload)},
+	} {
+		if _, err := provider.VerifyWebhook(tc.payload, tc.signature); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: got %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
+
+func TestFakePaymentFlow(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	provider, err := NewFakeProvider(db, "test-webhook-secret")
+	if err != nil {
+		t.Fatalf("NewFakeProvider: %v", err)
+	}
+
+	intent, err := provider.CreateIntent(ctx, "ORD-TEST-0001", 100)
+	if err != nil {
+		t.Fatalf("CreateIntent: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Delete(&fakeIntent{}, "id = ?", intent.ID)
+	})
+
+	if err := provider.Capture(ctx, intent.ID); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("capture before authorization: got %v, want ErrInvalidState", err)
+	}
+
+	payload, signature, err := provider.Authorize(ctx, intent.ID)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	event, err := provider.VerifyWebhook(payload, signature)
+	if err != nil {
+		t.Fatalf("VerifyWebhook: %v", err)
+	}
+	if event.IntentID != intent.ID || event.Amount != intent.Amount {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	if _, _, err := provider.Authorize(ctx, intent.ID); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("second authorization: got %v, want ErrInvalidState", err)
+	}
+
+	if err := provider.Capture(ctx, intent.ID); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+
+	// Новый экземпляр провайдера — как после перезапуска сервера
+	restarted, err := NewFakeProvider(db, "test-webhook-secret")
+	if err != nil {
+		t.Fatalf("NewFakeProvider: %v", err)
+	}
+
+	refundID, err := restarted.Refund(ctx, intent.ID, intent.ID+"-1", 60)
+	if err != nil {
+		t.Fatalf("Refund after restart: %v", err)
+	}
+	// Повтор с тем же ключом не возвращает деньги второй раз
+	if replayedID, err := restarted.Refund(ctx, intent.ID, intent.ID+"-1", 60); err != nil || replayedID != refundID {
+		t.Errorf("replayed refund: got %q, %v, want %q", replayedID, err, refundID)
+	}
+	if _, err := restarted.Refund(ctx, intent.ID, intent.ID+"-2", 50); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("refund over captured amount: got %v, want ErrInvalidState", err)
+	}
+	if _, err := restarted.Refund(ctx, intent.ID, intent.ID+"-3", 40); err != nil {
+		t.Errorf("refund of the rest: %v", err)
+	}
+
+	if err := restarted.Capture(ctx, "fake_pi_missing"); !errors.Is(err, ErrIntentNotFound) {
+		t.Errorf("unknown intent: got %v, want ErrIntentNotFound", err)
+	}
+}
+
+// openTestDB подключается к базе из POSTGRES_STRING, без неё тест пропускается
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	postgresString := os.Getenv("POSTGRES_STRING")
+	if postgresString == "" {
+		t.Skip("POSTGRES_STRING is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(postgresString), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	return db
+}
+
+func hexSignature(provider *FakeProvider, payload []byte) string {
+	return hex.EncodeToString(provider.sign(payload))
+}
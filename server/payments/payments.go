@@ -0,0 +1,77 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// Типы событий, которые провайдер присылает на вебхук
+const (
+	EventPaymentAuthorized = "payment.authorized"
+	EventPaymentSucceeded  = "payment.succeeded"
+	EventPaymentFailed     = "payment.failed"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrIntentNotFound   = errors.New("payment intent not found")
+	ErrInvalidState     = errors.New("payment intent is in invalid state")
+)
+
+// Intent — платёж, созданный у провайдера под заказ
+type Intent struct {
+	ID           string  `json:"intent_id"`
+	ClientSecret string  `json:"client_secret"`
+	Amount       float64 `json:"amount"`
+}
+
+// WebhookEvent — проверенное событие от провайдера
+type WebhookEvent struct {
+	ID       string  `json:"id"`
+	Type     string  `json:"type"`
+	IntentID string  `json:"intent_id"`
+	Amount   float64 `json:"amount"`
+}
+
+// PaymentProvider скрывает конкретную платёжную систему
+type PaymentProvider interface {
+	Name() string
+	// CreateIntent заводит платёж на amount для заказа orderNumber
+	CreateIntent(ctx context.Context, orderNumber string, amount float64) (Intent, error)
+	// Capture списывает ранее авторизованный платёж
+	Capture(ctx context.Context, intentID string) error
+	// Refund возвращает amount по списанному платежу и отдаёт id возврата.
+	// Повторный вызов с тем же idempotencyKey не возвращает деньги второй раз,
+	// а отдаёт id уже сделанного возврата
+	Refund(ctx context.Context, intentID string, idempotencyKey string, amount float64) (string, error)
+	// VerifyWebhook проверяет подпись вебхука и разбирает событие
+	VerifyWebhook(payload []byte, signature string) (WebhookEvent, error)
+}
+
+var Provider PaymentProvider
+
+// Open выбирает платёжного провайдера по имени. Пока есть только
+// встроенный fake для локальной разработки и тестов, и его нужно
+// включить явно. Без секрета вебхуков сервер не запускается: иначе
+// подпись вебхука мог бы подделать кто угодно.
+func Open(name string, webhookSecret string, db *gorm.DB) {
+	if webhookSecret == "" {
+		log.Fatalf("PAYMENT_WEBHOOK_SECRET is not set")
+	}
+
+	switch name {
+	case "fake":
+		provider, err := NewFakeProvider(db, webhookSecret)
+		if err != nil {
+			log.Fatalf("Error on opening fake payment provider: %v", err)
+		}
+		Provider = provider
+	case "":
+		log.Fatalf("PAYMENT_PROVIDER is not set")
+	default:
+		log.Fatalf("Unknown payment provider: %s", name)
+	}
+}
@@ -0,0 +1,124 @@
+package postgres
+
+// Ручные миграции, которые AutoMigrate сделать не умеет. Все они
+// идемпотентны и выполняются при каждом запуске из Open.
+
+const dropCascadingOrderItemsConstraint = `DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_order_items_product' AND confdeltype = 'c') THEN
+		ALTER TABLE order_items DROP CONSTRAINT fk_order_items_product;
+	END IF;
+END $$`
+
+// dropFullGuestTokenIndex снимает старый уникальный индекс по всем корзинам,
+// включая удалённые. AutoMigrate создаст вместо него частичный.
+const dropFullGuestTokenIndex = `DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_carts_guest_token' AND indexdef NOT LIKE '%WHERE%') THEN
+		DROP INDEX idx_carts_guest_token;
+	END IF;
+END $$`
+
+const deduplicateOrderNumbers = `DO $$
+BEGIN
+	IF to_regclass('orders') IS NOT NULL THEN
+		UPDATE orders SET order_number = order_number || '-' || id
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY order_number ORDER BY id) AS rn FROM orders
+			) numbered WHERE rn > 1
+		);
+	END IF;
+END $$`
+
+// seedOrderNumberCounters продолжает счётчики с наибольших уже выданных номеров
+const seedOrderNumberCounters = `INSERT INTO order_number_counters (year, value)
+SELECT split_part(order_number, '-', 2)::int, MAX(split_part(order_number, '-', 3)::bigint)
+FROM orders
+WHERE order_number ~ '^ORD-[0-9]{4}-[0-9]+'
+GROUP BY 1
+ON CONFLICT (year) DO UPDATE SET value = GREATEST(order_number_counters.value, EXCLUDED.value)`
+
+// backfillOrderItemsSnapshot заполняет снимок для позиций, созданных до его появления.
+// Исторических цен не сохранилось, поэтому берутся текущие.
+const backfillOrderItemsSnapshot = `UPDATE order_items SET
+	title = products.title,
+	image = products.image,
+	unit_price = products.price,
+	line_total = products.price * order_items.quantity
+FROM products
+WHERE order_items.product_id = products.id AND order_items.title IS NULL`
+
+// productSearchMigration поддерживает полнотекстовый индекс товаров.
+// Данные смешанные, поэтому каждое поле индексируется и русским, и английским словарём.
+var productSearchMigration = []string{
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('russian', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('russian', coalesce(category, '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(category, '')), 'B') ||
+		setweight(to_tsvector('russian', coalesce(description, '')), 'C') ||
+		setweight(to_tsvector('english', coalesce(description, '')), 'C')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+}
+
+// categoriesFromProducts заводит категорию для каждой строки products.category,
+// привязывает к ней товары и приводит products.category к slug. Повторный запуск
+// ничего не меняет: товары с category_id уже пропускаются.
+var categoriesFromProducts = []string{
+	`INSERT INTO categories (slug, names, sort_order, created_at, updated_at)
+	SELECT DISTINCT regexp_replace(lower(trim(category)), '\s+', '-', 'g'), jsonb_build_object('ru', trim(category), 'en', trim(category)), 0, now(), now()
+	FROM products
+	WHERE category_id IS NULL AND trim(coalesce(category, '')) <> ''
+	ON CONFLICT (slug) WHERE deleted_at IS NULL DO NOTHING`,
+	`UPDATE products SET category_id = categories.id, category = categories.slug
+	FROM categories
+	WHERE products.category_id IS NULL
+		AND categories.deleted_at IS NULL
+		AND categories.slug = regexp_replace(lower(trim(products.category)), '\s+', '-', 'g')`,
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_products_category') THEN
+			ALTER TABLE products ADD CONSTRAINT fk_products_category
+				FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE RESTRICT;
+		END IF;
+	END $$`,
+}
+
+// couponCategoriesToIDs переводит купоны со строки категории на category_id.
+// Строка приводится к slug так же, как в categoriesFromProducts. Купоны, чья
+// категория не нашлась, выключаются: без категории скидка досталась бы всей корзине.
+const couponCategoriesToIDs = `DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'coupons' AND column_name = 'category') THEN
+		UPDATE coupons SET category_id = categories.id
+		FROM categories
+		WHERE coupons.category_id IS NULL
+			AND categories.deleted_at IS NULL
+			AND categories.slug = regexp_replace(lower(trim(coupons.category)), '\s+', '-', 'g');
+		UPDATE coupons SET active = false
+		WHERE category_id IS NULL AND trim(coalesce(category, '')) <> '';
+		ALTER TABLE coupons DROP COLUMN category;
+	END IF;
+END $$`
+
+// variantsFromProducts заводит вариант по умолчанию каждому товару без вариантов,
+// включая удалённые (на них ссылаются старые заказы), и привязывает к нему
+// существующие позиции корзин и заказов
+var variantsFromProducts = []string{
+	`INSERT INTO product_variants (product_id, sku, price, stock, attributes, is_default, created_at, updated_at)
+	SELECT products.id, 'SKU-' || products.id, products.price, products.stock, '{}'::jsonb, true, now(), now()
+	FROM products
+	WHERE NOT EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id)`,
+	`UPDATE cart_items SET variant_id = product_variants.id
+	FROM product_variants
+	WHERE cart_items.variant_id IS NULL
+		AND product_variants.product_id = cart_items.product_id
+		AND product_variants.is_default AND product_variants.deleted_at IS NULL`,
+	`UPDATE order_items SET variant_id = product_variants.id, sku = product_variants.sku
+	FROM product_variants
+	WHERE order_items.variant_id IS NULL AND order_items.sku IS NULL
+		AND product_variants.product_id = order_items.product_id
+		AND product_variants.is_default AND product_variants.deleted_at IS NULL`,
+}
@@ -15,20 +15,90 @@ var DB *gorm.DB
 func Open(postgresString string) {
 	var err error
 
-	DB, err = gorm.Open(postgres.Open(fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-	os.Getenv("DB_HOST"),
-	os.Getenv("DB_USER"),    // "postgres"
-	os.Getenv("DB_PASSWORD"),// ваш пароль
-	os.Getenv("DB_NAME"),    // имя БД
-	os.Getenv("DB_PORT"))), &gorm.Config{})
+	// Строка подключения целиком (например, для тестов) важнее отдельных DB_*
+	if postgresString == "" {
+		postgresString = fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_USER"),    // "postgres"
+		os.Getenv("DB_PASSWORD"),// ваш пароль
+		os.Getenv("DB_NAME"),    // имя БД
+		os.Getenv("DB_PORT"))
+	}
+
+	DB, err = gorm.Open(postgres.Open(postgresString), &gorm.Config{})
 
 	if err != nil {
 		log.Fatal("Error on accessing database")
 	}
 
-	migratingErr := DB.AutoMigrate(&models.Product{}, &models.User{}, &models.Feedback{}, &models.Cart{}, &models.CartItem{}, &models.Order{}, &models.OrderItem{})
+	// Раньше удаление товара каскадно удаляло строки заказов. Снимаем старое
+	// ограничение, AutoMigrate пересоздаст его с ON DELETE SET NULL
+	if err := DB.Exec(dropCascadingOrderItemsConstraint).Error; err != nil {
+		log.Fatalf("Error on migrating order items: %v", err)
+	}
+
+	// Старая нумерация могла выдать один номер двум заказам — делаем их
+	// уникальными до создания индекса
+	if err := DB.Exec(deduplicateOrderNumbers).Error; err != nil {
+		log.Fatalf("Error on migrating order numbers: %v", err)
+	}
+
+	if err := DB.Exec(dropFullGuestTokenIndex).Error; err != nil {
+		log.Fatalf("Error on migrating guest carts: %v", err)
+	}
+
+	// Товары, заведённые до учёта остатков, получат нулевой остаток: реального
+	// числа мы не знаем, поэтому их список выводится в лог для администратора
+	backfillsStock := DB.Migrator().HasTable(&models.Product{}) && !DB.Migrator().HasColumn(&models.Product{}, "Stock")
+
+	migratingErr := DB.AutoMigrate(&models.Product{}, &models.User{}, &models.Feedback{}, &models.Cart{}, &models.CartItem{}, &models.Order{}, &models.OrderItem{}, &models.Session{}, &models.OrderStatusEvent{}, &models.OrderNumberCounter{}, &models.Payment{}, &models.PaymentRefund{}, &models.ReturnRequest{}, &models.Coupon{}, &models.CouponRedemption{}, &models.Wishlist{}, &models.Address{}, &models.Category{}, &models.ProductVariant{}, &models.AttributeDefinition{}, &models.ProductImage{})
 
 	if migratingErr != nil {
 		log.Fatal("Error on migrating")
 	}
+
+	if backfillsStock {
+		var productIDs []uint
+		if err := DB.Model(&models.Product{}).Pluck("id", &productIDs).Error; err != nil {
+			log.Fatalf("Error on migrating product stock: %v", err)
+		}
+		if len(productIDs) > 0 {
+			log.Printf("stock is unknown for products %v, it is set to 0 until an admin sets it", productIDs)
+		}
+	}
+
+	if err := DB.Exec(backfillOrderItemsSnapshot).Error; err != nil {
+		log.Fatalf("Error on backfilling order items: %v", err)
+	}
+
+	if err := DB.Exec(seedOrderNumberCounters).Error; err != nil {
+		log.Fatalf("Error on seeding order number counters: %v", err)
+	}
+
+	for _, statement := range categoriesFromProducts {
+		if err := DB.Exec(statement).Error; err != nil {
+			log.Fatalf("Error on migrating product categories: %v", err)
+		}
+	}
+
+	if err := DB.Exec(couponCategoriesToIDs).Error; err != nil {
+		log.Fatalf("Error on migrating coupon categories: %v", err)
+	}
+
+	for _, statement := range variantsFromProducts {
+		if err := DB.Exec(statement).Error; err != nil {
+			log.Fatalf("Error on migrating product variants: %v", err)
+		}
+	}
+
+	// До появления статусов заказы создавались со статусом "Создан"
+	if err := DB.Model(&models.Order{}).Where("status = ?", "Создан").Update("status", models.OrderStatusCreated).Error; err != nil {
+		log.Fatalf("Error on migrating order statuses: %v", err)
+	}
+
+	for _, statement := range productSearchMigration {
+		if err := DB.Exec(statement).Error; err != nil {
+			log.Fatalf("Error on migrating product search: %v", err)
+		}
+	}
 }
\ No newline at end of file
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Blob — содержимое файла из хранилища. Вызывающий обязан закрыть его.
+type Blob struct {
+	io.ReadCloser
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore скрывает, где лежат загруженные файлы: на диске или в S3
+type BlobStore interface {
+	Name() string
+	// Put сохраняет size байт из r под ключом key, перезаписывая старый файл
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get открывает файл; если его нет, возвращает ErrBlobNotFound
+	Get(ctx context.Context, key string) (*Blob, error)
+	// Delete удаляет файл; отсутствие файла ошибкой не считается
+	Delete(ctx context.Context, key string) error
+}
+
+var Blobs BlobStore
+
+// Open выбирает хранилище по имени: local (по умолчанию) хранит файлы
+// в IMAGE_DIR, s3 — в бакете любого S3-совместимого хранилища.
+func Open(name string) {
+	switch name {
+	case "", "local":
+		dir := os.Getenv("IMAGE_DIR")
+		if dir == "" {
+			dir = "productImages"
+		}
+
+		store, err := NewLocalStore(dir)
+		if err != nil {
+			log.Fatalf("Error on opening image directory: %v", err)
+		}
+		Blobs = store
+	case "s3":
+		// Тело файла не входит в подпись, без TLS его может подменить посредник
+		if strings.HasPrefix(os.Getenv("S3_ENDPOINT"), "http://") {
+			log.Printf("S3_ENDPOINT is plain HTTP: uploaded files are not protected from tampering in transit")
+		}
+		Blobs = NewS3Store(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		log.Fatalf("Unknown blob store: %s", name)
+	}
+}
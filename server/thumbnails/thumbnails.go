@@ -0,0 +1,405 @@
+package thumbnails
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+// Sizes — допустимые ширина и высота миниатюр. Произвольные размеры не
+// принимаются: каждый новый размер — это декодирование оригинала и ещё
+// один файл в кэше, и перебором размеров можно было бы занять сервер
+var Sizes = []int{64, 128, 256, 340, 512, 800, 1024, 1600}
+
+// Кэш по умолчанию, если размер не задан
+const defaultCacheSize = 1 << 30
+
+// Время последнего запроса миниатюры хранится в mtime файла и
+// обновляется не чаще этого интервала
+const touchInterval = time.Hour
+
+// Картинки больше этого числа пикселей не декодируются: распакованный
+// GIF или PNG может занять в памяти в сотни раз больше, чем файл
+const maxSourcePixels = 40_000_000
+
+type Fit string
+
+const (
+	// Вписать в рамку с сохранением пропорций
+	FitContain Fit = "contain"
+	// Заполнить рамку целиком, обрезав лишнее по центру
+	FitCover Fit = "cover"
+	// Растянуть ровно до рамки без сохранения пропорций
+	FitFill Fit = "fill"
+)
+
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatWebP Format = "webp"
+)
+
+var (
+	ErrInvalidOptions   = errors.New("invalid thumbnail options")
+	ErrUnsupportedImage = errors.New("image cannot be decoded")
+)
+
+// Options — параметры миниатюры из запроса. Нулевые Options означают оригинал.
+type Options struct {
+	Width  int
+	Height int
+	Fit    Fit
+	Format Format
+}
+
+// ParseOptions разбирает параметры width, height, fit и format
+func ParseOptions(width, height, fit, format string) (Options, error) {
+	var options Options
+
+	for _, param := range []struct {
+		name  string
+		value string
+		dst   *int
+	}{{"width", width, &options.Width}, {"height", height, &options.Height}} {
+		if param.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(param.value)
+		if err != nil || !slices.Contains(Sizes, n) {
+			return Options{}, fmt.Errorf("%w: %s must be one of %v", ErrInvalidOptions, param.name, Sizes)
+		}
+		*param.dst = n
+	}
+
+	switch Fit(strings.ToLower(fit)) {
+	case "", FitContain:
+		options.Fit = FitContain
+	case FitCover:
+		options.Fit = FitCover
+	case FitFill:
+		options.Fit = FitFill
+	default:
+		return Options{}, fmt.Errorf("%w: fit must be contain, cover or fill", ErrInvalidOptions)
+	}
+
+	switch Format(strings.ToLower(format)) {
+	case "":
+	case "jpg", FormatJPEG:
+		options.Format = FormatJPEG
+	case FormatPNG:
+		options.Format = FormatPNG
+	case FormatWebP:
+		options.Format = FormatWebP
+	default:
+		return Options{}, fmt.Errorf("%w: format must be jpeg, png or webp", ErrInvalidOptions)
+	}
+
+	if options.Width == 0 && options.Height == 0 && options.Format == "" {
+		return Options{}, nil
+	}
+	return options, nil
+}
+
+// IsOriginal сообщает, что ни размер, ни формат не запрошены
+func (o Options) IsOriginal() bool {
+	return o == Options{}
+}
+
+// WithSourceFormat выбирает формат по умолчанию: jpeg для jpeg, webp для webp,
+// для остальных (gif, png) — png, чтобы не потерять прозрачность
+func (o Options) WithSourceFormat(contentType string) Options {
+	if o.Format != "" {
+		return o
+	}
+
+	switch contentType {
+	case "image/jpeg":
+		o.Format = FormatJPEG
+	case "image/webp":
+		o.Format = FormatWebP
+	default:
+		o.Format = FormatPNG
+	}
+	return o
+}
+
+func (o Options) ContentType() string {
+	return "image/" + string(o.Format)
+}
+
+// Name — имя файла миниатюры в кэше. Ключи оригиналов не меняются после
+// загрузки, поэтому имя однозначно определяет содержимое.
+func (o Options) Name(key string) string {
+	return fmt.Sprintf("%s.%dx%d-%s.%s", key, o.Width, o.Height, o.Fit, o.Format)
+}
+
+// Render декодирует оригинал из src и строит миниатюру. У Options должен
+// быть выбран формат. GIF уменьшается по первому кадру.
+func Render(src io.Reader, o Options) ([]byte, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if config.Width*config.Height > maxSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d is too large", ErrUnsupportedImage, config.Width, config.Height)
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	img = resize(img, o)
+
+	var buf bytes.Buffer
+	switch o.Format {
+	case FormatJPEG:
+		// В jpeg нет прозрачности: кладём картинку на белый фон, иначе
+		// прозрачные области станут чёрными
+		background := imaging.New(img.Bounds().Dx(), img.Bounds().Dy(), color.White)
+		img = imaging.Overlay(background, img, image.Pt(0, 0), 1)
+		err = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(85))
+	case FormatPNG:
+		err = imaging.Encode(&buf, img, imaging.PNG, imaging.PNGCompressionLevel(png.BestCompression))
+	case FormatWebP:
+		// webp кодируется без потерь (VP8L): кодировщика с потерями на чистом Go нет
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("%w: format is not set", ErrInvalidOptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// resize уменьшает картинку под Options. Увеличение не делается:
+// миниатюра не бывает больше оригинала.
+func resize(img image.Image, o Options) image.Image {
+	bounds := img.Bounds()
+	width, height := min(o.Width, bounds.Dx()), min(o.Height, bounds.Dy())
+
+	switch {
+	case width == 0 && height == 0:
+		return img
+	case width == 0 || height == 0:
+		// Задана одна сторона — вторая считается по пропорциям
+		return imaging.Resize(img, width, height, imaging.Lanczos)
+	}
+
+	switch o.Fit {
+	case FitCover:
+		return imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+	case FitFill:
+		return imaging.Resize(img, width, height, imaging.Lanczos)
+	default:
+		return imaging.Fit(img, width, height, imaging.Lanczos)
+	}
+}
+
+// DiskCache хранит готовые миниатюры на диске. Одновременные запросы одной
+// миниатюры строят её один раз. Когда кэш превышает maxBytes, удаляются
+// миниатюры, которые дольше всего не запрашивали.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+	group    singleflight.Group
+
+	mu   sync.Mutex
+	size int64
+}
+
+var Cache *DiskCache
+
+// Open готовит кэш миниатюр в каталоге dir размером не больше maxBytes
+func Open(dir string, maxBytes int64) {
+	if dir == "" {
+		dir = "thumbnailCache"
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheSize
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("Error on opening thumbnail cache: %v", err)
+	}
+	Cache = &DiskCache{dir: dir, maxBytes: maxBytes}
+
+	// Размер кэша, оставшегося с прошлого запуска
+	Cache.mu.Lock()
+	Cache.evict()
+	Cache.mu.Unlock()
+}
+
+// Open открывает миниатюру оригинала key. Если её ещё нет, она строится
+// функцией render и сохраняется.
+func (c *DiskCache) Open(key string, o Options, render func() ([]byte, error)) (*os.File, error) {
+	name := o.Name(filepath.Base(key))
+	path := filepath.Join(c.dir, name)
+
+	if file, err := os.Open(path); err == nil {
+		c.touch(file)
+		return file, nil
+	}
+
+	_, err, _ := c.group.Do(name, func() (interface{}, error) {
+		if _, err := os.Stat(path); err == nil {
+			return nil, nil
+		}
+
+		data, err := render()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeFileAtomic(c.dir, path, data); err != nil {
+			return nil, err
+		}
+		c.grow(int64(len(data)))
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(path)
+}
+
+// Purge удаляет все миниатюры оригинала key
+func (c *DiskCache) Purge(key string) error {
+	matches, err := filepath.Glob(filepath.Join(c.dir, filepath.Base(key)+".*"))
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(match); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		c.grow(-info.Size())
+	}
+	return nil
+}
+
+// touch отмечает, что миниатюру запросили: по mtime выбираются миниатюры на удаление
+func (c *DiskCache) touch(file *os.File) {
+	info, err := file.Stat()
+	if err != nil || time.Since(info.ModTime()) < touchInterval {
+		return
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(file.Name(), now, now); err != nil {
+		log.Printf("error on touching thumbnail %s: %v", file.Name(), err)
+	}
+}
+
+func (c *DiskCache) grow(delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size += delta
+	if c.size > c.maxBytes {
+		c.evict()
+	}
+}
+
+// evict пересчитывает размер кэша и, если он больше лимита, удаляет давно
+// не запрошенные миниатюры, пока кэш не займёт 90% лимита. Вызывается под mu.
+func (c *DiskCache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("error on reading thumbnail cache: %v", err)
+		return
+	}
+
+	type cached struct {
+		path string
+		size int64
+		used time.Time
+	}
+
+	var files []cached
+	var total int64
+	for _, entry := range entries {
+		// Временные файлы недописанных миниатюр начинаются с точки
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, cached{filepath.Join(c.dir, entry.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	if total > c.maxBytes {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].used.Before(files[j].used)
+		})
+
+		target := c.maxBytes / 10 * 9
+		for _, file := range files {
+			if total <= target {
+				break
+			}
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("error on evicting thumbnail %s: %v", file.path, err)
+				continue
+			}
+			total -= file.size
+		}
+	}
+
+	c.size = total
+}
+
+func writeFileAtomic(dir string, path string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, ".thumbnail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,129 @@
+package thumbnails
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	for _, tc := range []struct {
+		width, height, fit, format string
+		want                       Options
+		wantErr                    bool
+	}{
+		{want: Options{}},
+		{width: "340", want: Options{Width: 340, Fit: FitContain}},
+		{width: "800", height: "800", fit: "COVER", format: "jpg", want: Options{Width: 800, Height: 800, Fit: FitCover, Format: FormatJPEG}},
+		{format: "png", want: Options{Fit: FitContain, Format: FormatPNG}},
+		{width: "341", wantErr: true},
+		{height: "2000", wantErr: true},
+		{width: "-64", wantErr: true},
+		{width: "64", fit: "stretch", wantErr: true},
+		{width: "128", format: "WEBP", want: Options{Width: 128, Fit: FitContain, Format: FormatWebP}},
+		{format: "gif", wantErr: true},
+	} {
+		got, err := ParseOptions(tc.width, tc.height, tc.fit, tc.format)
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("ParseOptions(%q, %q, %q, %q): got %v, want ErrInvalidOptions", tc.width, tc.height, tc.fit, tc.format, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseOptions(%q, %q, %q, %q) = %+v, %v; want %+v", tc.width, tc.height, tc.fit, tc.format, got, err, tc.want)
+		}
+	}
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	Open(dir, 1000)
+
+	options := Options{Width: 64, Fit: FitContain, Format: FormatPNG}
+	render := func() ([]byte, error) { return make([]byte, 300), nil }
+
+	// mtime задаём явно, чтобы порядок не зависел от точности часов файловой системы
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("image%d.png", i)
+		file, err := Cache.Open(key, options, render)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", key, err)
+		}
+		file.Close()
+
+		used := time.Now().Add(time.Duration(i-10) * time.Hour)
+		if err := os.Chtimes(file.Name(), used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Четвёртая миниатюра переполняет кэш: удаляется самая старая
+	file, err := Cache.Open("image3.png", options, render)
+	if err != nil {
+		t.Fatalf("Open(image3.png): %v", err)
+	}
+	file.Close()
+
+	for i, want := range []bool{false, true, true, true} {
+		_, err := os.Stat(filepath.Join(dir, options.Name(fmt.Sprintf("image%d.png", i))))
+		if exists := err == nil; exists != want {
+			t.Errorf("image%d thumbnail exists = %v, want %v", i, exists, want)
+		}
+	}
+	if Cache.size != 900 {
+		t.Errorf("cache size = %d, want 900", Cache.size)
+	}
+
+	if err := Cache.Purge("image3.png"); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if Cache.size != 600 {
+		t.Errorf("cache size after purge = %d, want 600", Cache.size)
+	}
+}
+
+func TestRenderWebP(t *testing.T) {
+	// Полупрозрачный оригинал: webp, в отличие от jpeg, должен сохранить альфу
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 256; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 128})
+		}
+	}
+	var original bytes.Buffer
+	if err := png.Encode(&original, src); err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{Width: 128, Fit: FitContain}.WithSourceFormat("image/webp")
+	if options.Format != FormatWebP || options.ContentType() != "image/webp" {
+		t.Fatalf("WithSourceFormat(image/webp) = %+v", options)
+	}
+
+	data, err := Render(&original, options)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding rendered webp: %v", err)
+	}
+	if format != "webp" {
+		t.Errorf("rendered format = %s, want webp", format)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(128, 64) {
+		t.Errorf("rendered size = %v, want 128x64", size)
+	}
+	if _, _, _, a := img.At(10, 10).RGBA(); a>>8 != 128 {
+		t.Errorf("rendered alpha = %d, want 128", a>>8)
+	}
+}